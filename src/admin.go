@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// getAdminTarget returns the user named in the username form value of the request.
+// If the user is cached, the cached DBUser is returned so changes are reflected there too.
+func getAdminTarget(w http.ResponseWriter, r *http.Request) (*DBUser, bool) {
+	name := r.PostFormValue("username")
+	dbUser, err := App.DB.GetUserByName(name)
+	if err != nil {
+		if IsNotFoundError(err) {
+			writeAPIError(w, http.StatusNotFound, "User not found")
+			return nil, false
+		}
+		fmt.Fprintf(os.Stderr, "cannot fetch user with name %s from database: %v\n", name, err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot fetch user")
+		return nil, false
+	}
+
+	if u, ok := App.Users.GetByID(dbUser.ID); ok {
+		return u.DBUser, true
+	}
+	return dbUser, true
+}
+
+// startAdminAction checks the request is a POST made by an user granted
+// the permission and returns the targeted user.
+func startAdminAction(w http.ResponseWriter, r *http.Request, p Permission) (mod *User, target *DBUser, ok bool) {
+	if r.Method != "POST" {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return nil, nil, false
+	}
+
+	mod, ok = requirePermission(w, r, p)
+	if !ok {
+		return nil, nil, false
+	}
+
+	target, ok = getAdminTarget(w, r)
+	return mod, target, ok
+}
+
+// refreshUserInfo sends the user's updated information to all of their connections.
+func refreshUserInfo(uid uint) {
+	for _, conn := range connectionsOfUser(uid) {
+		sendUserInfo(conn)
+	}
+}
+
+// outranks returns whenever the moderator is allowed to act on the target,
+// responding with 403 Forbidden if not.
+func outranks(w http.ResponseWriter, mod *User, target *DBUser) bool {
+	if App.Users.Snapshot(mod.DBUser).Role.Level() <= App.Users.Snapshot(target).Role.Level() {
+		writeAPIError(w, http.StatusForbidden, "Cannot act on users with the same or higher role")
+		return false
+	}
+	return true
+}
+
+// setBan bans the target until expiry (or removes the ban if nil) and sets their role.
+// The role they had before being (shadow)banned is kept to be given back by unbannedRole.
+// It returns whenever the ban was set.
+func setBan(w http.ResponseWriter, mod *User, target *DBUser, expiry *time.Time, reason string, role UserRole) bool {
+	if !outranks(w, mod, target) {
+		return false
+	}
+
+	var state = App.Users.Snapshot(target)
+	var previousRole = state.PreviousRole
+	if !role.IsBan() {
+		previousRole = ""
+	} else if !state.Role.IsBan() {
+		previousRole = state.Role
+	}
+
+	if err := App.DB.SetUserBan(target.ID, expiry, reason, role, previousRole); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot set ban of user with ID %d in database: %v\n", target.ID, err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot update user")
+		return false
+	}
+
+	App.Users.Update(target, func(u *DBUser) {
+		u.BanExpiry = expiry
+		u.BanReason = reason
+		u.Role = role
+		u.PreviousRole = previousRole
+	})
+	refreshUserInfo(target.ID)
+	return true
+}
+
+// rollbackBanned rolls back the pixels the target placed within the seconds in the
+// rollback_time form value, if set and the moderator is granted RollbackPermission.
+func rollbackBanned(r *http.Request, mod *User, target *DBUser) {
+	if r.PostFormValue("rollback_time") == "" || !hasPermission(mod, RollbackPermission) {
+		return
+	}
+
+	d, err := getRollbackDuration(r, "rollback_time")
+	if err != nil || d == 0 {
+		return
+	}
+
+	if err := rollbackUser(mod, target.ID, d); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot rollback pixels of user with ID %d in database: %v\n", target.ID, err)
+	}
+}
+
+// unbannedRole returns the role the user should have when not (shadow)banned:
+// the one they had before being banned.
+func unbannedRole(u *DBUser) UserRole {
+	if !u.Role.IsBan() {
+		return u.Role
+	}
+	if u.PreviousRole != "" {
+		return u.PreviousRole
+	}
+	return DefaultUserRole
+}
+
+// tempbannedRole returns the role the user should have when temporarily banned:
+// a permaban is replaced by the temporary ban, but a shadowban is kept.
+func tempbannedRole(u *DBUser) UserRole {
+	if u.Role == BannedUserRole {
+		return unbannedRole(u)
+	}
+	return u.Role
+}
+
+// handleAdminBan temporarily bans an user for the amount of seconds in the time form value.
+func handleAdminBan(w http.ResponseWriter, r *http.Request) {
+	mod, target, ok := startAdminAction(w, r, BanPermission)
+	if !ok {
+		return
+	}
+
+	secs, err := strconv.ParseFloat(r.PostFormValue("time"), 64)
+	if err != nil || secs <= 0 {
+		writeAPIError(w, http.StatusBadRequest, "Invalid ban length")
+		return
+	}
+
+	expiry := time.Now().Add(time.Duration(secs * float64(time.Second)))
+	state := App.Users.Snapshot(target)
+	if setBan(w, mod, target, &expiry, r.PostFormValue("reason"), tempbannedRole(&state)) {
+		rollbackBanned(r, mod, target)
+	}
+}
+
+// handleAdminPermaban bans an user permanently.
+func handleAdminPermaban(w http.ResponseWriter, r *http.Request) {
+	mod, target, ok := startAdminAction(w, r, PermabanPermission)
+	if !ok {
+		return
+	}
+
+	if setBan(w, mod, target, nil, r.PostFormValue("reason"), BannedUserRole) {
+		rollbackBanned(r, mod, target)
+	}
+}
+
+// handleAdminShadowban shadowbans an user: their placements are only shown to themselves.
+func handleAdminShadowban(w http.ResponseWriter, r *http.Request) {
+	mod, target, ok := startAdminAction(w, r, ShadowbanPermission)
+	if !ok {
+		return
+	}
+
+	if setBan(w, mod, target, nil, r.PostFormValue("reason"), ShadowbannedUserRole) {
+		rollbackBanned(r, mod, target)
+	}
+}
+
+// handleAdminUnban removes any kind of ban from an user.
+func handleAdminUnban(w http.ResponseWriter, r *http.Request) {
+	mod, target, ok := startAdminAction(w, r, BanPermission)
+	if !ok {
+		return
+	}
+
+	state := App.Users.Snapshot(target)
+	setBan(w, mod, target, nil, "", unbannedRole(&state))
+}
+
+type apiAdminCheck struct {
+	Username        string   `json:"username"`
+	Login           string   `json:"login"`
+	Role            UserRole `json:"role"`
+	RenameRequested bool     `json:"renameRequested"`
+
+	IsBanned  bool   `json:"banned"`
+	BanExpiry int64  `json:"banExpiry"`
+	BanReason string `json:"ban_reason"`
+
+	IsChatBanned       bool   `json:"chatBanned"`
+	ChatBanExpiry      int64  `json:"chatbanExpiry"`
+	ChatBanReason      string `json:"chatbanReason"`
+	ChatBanIsPermanent bool   `json:"chatbanIsPerma"`
+}
+
+// handleAdminCheck responds with the ban and chat ban state of an user.
+func handleAdminCheck(w http.ResponseWriter, r *http.Request) {
+	_, target, ok := startAdminAction(w, r, BanPermission)
+	if !ok {
+		return
+	}
+
+	var state = App.Users.Snapshot(target)
+	w.Header().Set("Content-Type", "text/json")
+	json.NewEncoder(w).Encode(apiAdminCheck{
+		Username:        state.Name,
+		Login:           state.Login.String(),
+		Role:            state.Role,
+		RenameRequested: state.IsRenameRequested,
+
+		IsBanned:  state.IsBanned(),
+		BanExpiry: toMillis(state.BanExpiry),
+		BanReason: state.BanReason,
+
+		IsChatBanned:       state.IsChatBanned(),
+		ChatBanExpiry:      toMillis(state.ChatBanExpiry),
+		ChatBanReason:      state.ChatBanReason,
+		ChatBanIsPermanent: state.IsPermanentlyChatBanned,
+	})
+}
+
+// handleAdminRole assigns the role in the role form value to an user.
+// (Shadow)banned users are given the role once unbanned.
+func handleAdminRole(w http.ResponseWriter, r *http.Request) {
+	mod, target, ok := startAdminAction(w, r, RolesPermission)
+	if !ok {
+		return
+	}
+
+	role := UserRole(r.PostFormValue("role"))
+	if !role.IsValid() || role.IsBan() {
+		writeAPIError(w, http.StatusBadRequest, "Invalid role")
+		return
+	}
+	if !outranks(w, mod, target) {
+		return
+	}
+
+	if App.Users.Snapshot(target).Role.IsBan() {
+		if err := App.DB.SetUserPreviousRole(target.ID, role); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot set previous role of user with ID %d in database: %v\n", target.ID, err)
+			writeAPIError(w, http.StatusInternalServerError, "Cannot update user")
+			return
+		}
+		App.Users.Update(target, func(u *DBUser) {
+			u.PreviousRole = role
+		})
+		return
+	}
+
+	if err := App.DB.SetUserRole(target.ID, role); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot set role of user with ID %d in database: %v\n", target.ID, err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot update user")
+		return
+	}
+
+	App.Users.Update(target, func(u *DBUser) {
+		u.Role = role
+	})
+	refreshUserInfo(target.ID)
+}
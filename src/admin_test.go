@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setTestRole sets the role of the user in the database and cache.
+func setTestRole(t *testing.T, u *User, role UserRole) {
+	if err := App.DB.SetUserRole(u.ID, role); err != nil {
+		t.Fatalf("cannot set role: %v", err)
+	}
+	App.Users.Update(u.DBUser, func(u *DBUser) { u.Role = role })
+}
+
+func TestUnbanRestoresRole(t *testing.T) {
+	tests := []struct {
+		name string
+		ban  func(target *User) UserRole
+	}{
+		{"permaban", func(*User) UserRole { return BannedUserRole }},
+		{"shadowban", func(*User) UserRole { return ShadowbannedUserRole }},
+		{"temporary ban over permaban", func(target *User) UserRole {
+			state := App.Users.Snapshot(target.DBUser)
+			return tempbannedRole(&state)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTestApp(t, "")
+			mod := newTestUser(t, "admin")
+			setTestRole(t, mod, AdminUserRole)
+			target := newTestUser(t, "mod")
+			setTestRole(t, target, ModeratorUserRole)
+
+			if !setBan(httptest.NewRecorder(), mod, target.DBUser, nil, "test", BannedUserRole) {
+				t.Fatal("cannot permaban")
+			}
+			expiry := time.Now().Add(time.Hour)
+			if !setBan(httptest.NewRecorder(), mod, target.DBUser, &expiry, "test", test.ban(target)) {
+				t.Fatal("cannot ban")
+			}
+
+			state := App.Users.Snapshot(target.DBUser)
+			if !setBan(httptest.NewRecorder(), mod, target.DBUser, nil, "", unbannedRole(&state)) {
+				t.Fatal("cannot unban")
+			}
+			if role := App.Users.Snapshot(target.DBUser).Role; role != ModeratorUserRole {
+				t.Errorf("unbanned user has role %s, expected %s", role, ModeratorUserRole)
+			}
+
+			dbUser, err := App.DB.GetUserByName("mod")
+			if err != nil {
+				t.Fatalf("cannot fetch user: %v", err)
+			}
+			if dbUser.Role != ModeratorUserRole || dbUser.PreviousRole != "" {
+				t.Errorf("unbanned user has role %s and previous role %q in database, expected %s and none", dbUser.Role, dbUser.PreviousRole, ModeratorUserRole)
+			}
+		})
+	}
+}
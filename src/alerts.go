@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const wsAlertType = "alert"
+const wsAdminMessageType = "admin_message"
+
+type wsAlert struct {
+	wsMessage
+	Message string `json:"message"`
+}
+
+type wsAdminMessageReq struct {
+	wsMessage
+	Username string   `json:"username"`
+	Role     UserRole `json:"role"`
+	Message  string   `json:"message"`
+}
+
+// invalidAlertError is returned when an alert can't be sent because of how it was requested.
+type invalidAlertError struct {
+	msg string
+}
+
+func (e *invalidAlertError) Error() string {
+	return e.msg
+}
+
+// getAnnouncementDuration returns how long announcements are shown to new connections by default.
+func getAnnouncementDuration() time.Duration {
+	return App.Conf.GetTimeDurationInfiniteNotAllowed("alerts.announcementDuration", time.Hour)
+}
+
+// sendAlert sends an alert with the message to every connection of the user with the given name,
+// to every connection of users with the given role, or, if neither is set, to every connection.
+// Alerts to every connection are stored as announcements active for duration,
+// so connections opened within it also receive them.
+func sendAlert(author *User, username string, role UserRole, message string, duration time.Duration) error {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return &invalidAlertError{"alert message is empty"}
+	}
+	if role != "" && !role.IsValid() {
+		return &invalidAlertError{fmt.Sprintf("unknown role %s", role)}
+	}
+
+	alertMsg := wsAlert{withType(wsAlertType), message}
+
+	switch {
+	case username != "":
+		dbUser, err := App.DB.GetUserByName(username)
+		if err != nil {
+			return err
+		}
+		for _, conn := range connectionsOfUser(dbUser.ID) {
+			conn.queue(alertMsg)
+		}
+	case role != "":
+		conns := Connections.Filter(func(conn *wsConn) bool {
+			return conn.user != nil && App.Users.Snapshot(conn.user.DBUser).Role == role
+		})
+		for _, conn := range conns {
+			conn.queue(alertMsg)
+		}
+	default:
+		if duration > 0 {
+			if _, err := App.DB.CreateAnnouncement(author, message, time.Now().Add(duration)); err != nil {
+				fmt.Fprintf(os.Stderr, "cannot save announcement by user with ID %d in database: %v\n", author.ID, err)
+			}
+		}
+		Connections.Broadcast(alertMsg)
+	}
+
+	return nil
+}
+
+// sendActiveAnnouncements sends every announcement that didn't expire yet through the connection.
+func sendActiveAnnouncements(conn *wsConn) {
+	announcements, err := App.DB.GetActiveAnnouncements()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot fetch active announcements from database: %v\n", err)
+		return
+	}
+
+	for _, a := range announcements {
+		conn.queue(wsAlert{withType(wsAlertType), a.Message})
+	}
+}
+
+// handleAdminMessage sends the alert requested by a staff member through the websocket.
+func handleAdminMessage(conn *wsConn, adminMsg wsAdminMessageReq) {
+	if !hasPermission(conn.user, AlertsPermission) {
+		return
+	}
+
+	err := sendAlert(conn.user, adminMsg.Username, adminMsg.Role, adminMsg.Message, getAnnouncementDuration())
+	if err != nil && !IsNotFoundError(err) {
+		fmt.Fprintf(os.Stderr, "cannot send alert by user with ID %d: %v\n", conn.user.ID, err)
+	}
+}
+
+// handleAdminAlert sends an alert with the message form value to the user in the username
+// form value, to users with the role in the role form value, or to everyone.
+// Announcements to everyone are shown to new connections for the seconds in the duration
+// form value, or alerts.announcementDuration if not set.
+func handleAdminAlert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	mod, ok := requirePermission(w, r, AlertsPermission)
+	if !ok {
+		return
+	}
+
+	duration := getAnnouncementDuration()
+	if rawDuration := r.PostFormValue("duration"); rawDuration != "" {
+		secs, err := strconv.ParseFloat(rawDuration, 64)
+		if err != nil || secs < 0 {
+			writeAPIError(w, http.StatusBadRequest, "Invalid announcement duration")
+			return
+		}
+		duration = time.Duration(secs * float64(time.Second))
+	}
+
+	err := sendAlert(mod, r.PostFormValue("username"), UserRole(r.PostFormValue("role")), r.PostFormValue("message"), duration)
+	if err != nil {
+		if _, ok := err.(*invalidAlertError); ok {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if IsNotFoundError(err) {
+			writeAPIError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "cannot send alert by user with ID %d: %v\n", mod.ID, err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot send alert")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sync"
 	"time"
 
 	"github.com/go-akka/configuration"
@@ -13,12 +14,18 @@ type Palette []int
 type Canvas struct {
 	Width  uint
 	Height uint
-	Board  []byte
+	// Board should only be accessed directly before the canvas is shared,
+	// use GetPixelColorIndex, SetPixelColor and Snapshot otherwise.
+	Board []byte
+
+	mu sync.RWMutex
+	// version increases every time a pixel is set.
+	version uint64
 }
 
 // NewCanvas creates new canvas with the width and height specified.
 func NewCanvas(w, h uint, bgColorIdx byte) *Canvas {
-	c := &Canvas{w, h, make([]byte, w*h)}
+	c := &Canvas{Width: w, Height: h, Board: make([]byte, w*h)}
 	for y := uint(0); y < h; y++ {
 		for x := uint(0); x < w; x++ {
 			c.Board[x+y*w] = bgColorIdx
@@ -29,27 +36,54 @@ func NewCanvas(w, h uint, bgColorIdx byte) *Canvas {
 
 // GetPixelColorIndex returns the color index of the pixel.
 func (c *Canvas) GetPixelColorIndex(x, y uint) byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.Board[x+y*c.Width]
 }
 
 // SetPixelColor sets the color index of a pixel.
 func (c *Canvas) SetPixelColor(x, y uint, colorIdx byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.Board[x+y*c.Width] = colorIdx
+	c.version++
+}
+
+// Snapshot returns a copy of the board and its version, which
+// changes whenever a pixel is set, taken while no pixel is being set.
+func (c *Canvas) Snapshot() (board []byte, version uint64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	board = make([]byte, len(c.Board))
+	copy(board, c.Board)
+	return board, c.version
+}
+
+// Version returns the version of the board, which changes whenever a pixel is set.
+func (c *Canvas) Version() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
 }
 
 // PxlsApp stores information about the game application.
 type PxlsApp struct {
-	Conf    configuration.Config
-	DB      Database
-	Canvas  Canvas
-	Palette Palette
-	Users   UserList
-}
-
-// GetCooldown returns the time in between placing pixels
-// players have to wait until they can place again.
-func (a *PxlsApp) GetCooldown() time.Duration {
-	var cooldown = a.Conf.GetTimeDurationInfiniteNotAllowed("cooldown")
-	// TODO(netux): apply math function used in Pxls' sourcecode
-	return cooldown
+	Conf       configuration.Config
+	DB         Database
+	Canvas     *Canvas
+	Heatmap    *Heatmap
+	Virginmap  *Virginmap
+	Palette    Palette
+	Users      *UserList
+	Limits     RateLimits
+	ChatFilter *ChatFilter
+
+	AuthProviders OAuthProviders
+	Captcha       CaptchaVerifier
+}
+
+// GetCooldown returns the time players have to wait after the placement
+// until they can place again, based on how many logged in users are online.
+func (a *PxlsApp) GetCooldown(p Placement) time.Duration {
+	return makeCooldownConfigFromConf(&a.Conf).Compute(Connections.CountUsers(), p)
 }
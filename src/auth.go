@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-akka/configuration"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// SessionTokenLength is the length of the session tokens given to users
+	SessionTokenLength = 32
+	// AuthStateLength is the length of the state used to verify OAuth callbacks
+	AuthStateLength = 24
+
+	// AuthStateCookie is the name of the cookie holding the OAuth state
+	AuthStateCookie = "pxls-auth-state"
+	// SessionCookieMaxAge is how long the pxls-token cookie lasts
+	SessionCookieMaxAge = 365 * 24 * time.Hour
+)
+
+// OAuthIdentity is the information a provider gives about an user.
+type OAuthIdentity struct {
+	// ID uniquely identifies the user in the provider.
+	ID string
+	// Name is the user's name in the provider.
+	Name string
+	// CreatedAt is when the account was created in the provider,
+	// or nil if the provider doesn't tell.
+	CreatedAt *time.Time
+}
+
+// OAuthProvider is a service users can sign in with.
+type OAuthProvider interface {
+	// ID returns the identifier of the provider, used in paths and logins.
+	ID() string
+	// Name returns the user-facing name of the provider.
+	Name() string
+	// MinAge returns how old accounts have to be to sign in.
+	MinAge() time.Duration
+	// AuthURL returns the URL the user has to visit to authorize us.
+	AuthURL(state string) (string, error)
+	// Identify reads the callback request and returns the user's identity.
+	Identify(r *http.Request, state string) (*OAuthIdentity, error)
+}
+
+// OAuthProviders contains sign in providers stored by ID.
+type OAuthProviders map[string]OAuthProvider
+
+// makeOAuthProvidersFromConf creates every provider in
+// the oauth block of the config file that has a key configured.
+func makeOAuthProvidersFromConf(conf *configuration.Config) OAuthProviders {
+	var providers = make(OAuthProviders)
+
+	callbackBase := strings.TrimSuffix(conf.GetString("oauth.callbackBase"), "/")
+	for id, makeProvider := range oauthProviderMakers {
+		path := "oauth." + id
+		key := conf.GetString(path + ".key")
+		if key == "" {
+			continue
+		}
+
+		urls := make(map[string]string)
+		for _, name := range oauthURLNames {
+			if u := conf.GetString(path + "." + name); u != "" {
+				urls[name] = u
+			}
+		}
+
+		providers[id] = makeProvider(oauthProviderConf{
+			key:         key,
+			secret:      conf.GetString(path + ".secret"),
+			minAge:      conf.GetTimeDuration(path+".minAge", 0),
+			callbackURL: callbackBase + "/" + id,
+			urls:        urls,
+		})
+	}
+
+	return providers
+}
+
+// setSessionCookie sets the pxls-token cookie on the response.
+func setSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pxls-token",
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(SessionCookieMaxAge),
+		HttpOnly: true,
+	})
+}
+
+// clearSessionCookie removes the pxls-token cookie from the client.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   "pxls-token",
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
+
+// createSession creates a session for the user and sets the pxls-token cookie.
+func createSession(w http.ResponseWriter, uid uint) (string, error) {
+	token, err := GenerateToken(SessionTokenLength)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate session token: %v", err)
+	}
+
+	if err := App.DB.SaveSessionForUser(uid, token); err != nil {
+		return "", fmt.Errorf("cannot save session for user with ID %d: %v", uid, err)
+	}
+
+	setSessionCookie(w, token)
+	return token, nil
+}
+
+func getReqAuthProvider(r *http.Request, prefix string) (OAuthProvider, bool) {
+	id := strings.TrimPrefix(r.URL.Path, prefix)
+	p, ok := App.AuthProviders[id]
+	return p, ok
+}
+
+type apiSignin struct {
+	URL string `json:"url"`
+}
+
+// handleSignin redirects the user to the provider's authorization page, or
+// responds with its URL if the redirect query parameter is not set.
+func handleSignin(w http.ResponseWriter, r *http.Request) {
+	p, ok := getReqAuthProvider(r, "/signin/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	state, err := GenerateToken(AuthStateLength)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot generate auth state: %v\n", err)
+		http.Error(w, "cannot sign in", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := p.AuthURL(state)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot get auth URL for %s: %v\n", p.ID(), err)
+		http.Error(w, "cannot sign in", http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     AuthStateCookie,
+		Value:    state,
+		Path:     "/auth/",
+		MaxAge:   int((10 * time.Minute) / time.Second),
+		HttpOnly: true,
+	})
+
+	if r.URL.Query().Get("redirect") != "" {
+		http.Redirect(w, r, authURL, http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/json")
+	json.NewEncoder(w).Encode(apiSignin{authURL})
+}
+
+// redirectAuthDone redirects to the page which passes the result of signing in to the client.
+func redirectAuthDone(w http.ResponseWriter, r *http.Request, params url.Values) {
+	http.Redirect(w, r, "/auth_done.html#"+params.Encode(), http.StatusFound)
+}
+
+// handleAuthCallback handles the provider redirecting the user back to us,
+// signing them in if their identity could be verified.
+func handleAuthCallback(w http.ResponseWriter, r *http.Request) {
+	p, ok := getReqAuthProvider(r, "/auth/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	ip, err := getReqIP(r)
+	if err != nil {
+		http.Error(w, "cannot get IP address", http.StatusBadRequest)
+		return
+	}
+
+	if !App.Limits.Allow("auth", ip) {
+		http.Error(w, "too many sign in attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	if r.URL.Query().Get("error") != "" {
+		// the user denied access
+		redirectAuthDone(w, r, url.Values{"nologin": {"true"}})
+		return
+	}
+
+	var state string
+	if c, err := r.Cookie(AuthStateCookie); err == nil {
+		state = c.Value
+	}
+	http.SetCookie(w, &http.Cookie{Name: AuthStateCookie, Path: "/auth/", MaxAge: -1})
+
+	identity, err := p.Identify(r, state)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot identify user through %s: %v\n", p.ID(), err)
+		http.Error(w, "cannot verify your identity, please try again", http.StatusBadRequest)
+		return
+	}
+
+	if minAge := p.MinAge(); minAge > 0 && identity.CreatedAt != nil && time.Since(*identity.CreatedAt) < minAge {
+		http.Error(w, fmt.Sprintf("your %s account is too young to sign in", p.Name()), http.StatusForbidden)
+		return
+	}
+
+	login := UserLogin{p.ID(), identity.ID}
+	dbUser, err := App.DB.GetUserByLogin(login)
+	if err != nil {
+		if !IsNotFoundError(err) {
+			fmt.Fprintf(os.Stderr, "cannot fetch user with login %s from database: %v\n", login.String(), err)
+			http.Error(w, "cannot sign in", http.StatusInternalServerError)
+			return
+		}
+
+		if !isRegistrationEnabled() {
+			http.Error(w, "registration is disabled", http.StatusForbidden)
+			return
+		}
+
+		// the user has to pick an username before we create them
+		signupToken, err := PendingSignups.Add(login)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot generate signup token: %v\n", err)
+			http.Error(w, "cannot sign in", http.StatusInternalServerError)
+			return
+		}
+
+		redirectAuthDone(w, r, url.Values{
+			"token":  {signupToken},
+			"signup": {"true"},
+		})
+		return
+	}
+
+	token, err := createSession(w, dbUser.ID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		http.Error(w, "cannot sign in", http.StatusInternalServerError)
+		return
+	}
+
+	redirectAuthDone(w, r, url.Values{
+		"token":  {token},
+		"signup": {"false"},
+	})
+}
+
+// handleLogout deletes the session of the request, or every session of
+// the user if the all query parameter is set, and closes every websocket
+// connection that was authenticated with them.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	token, err := getReqPxlsToken(r)
+	if err != nil && !IsNotFoundError(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clearSessionCookie(w)
+	if token == "" {
+		return
+	}
+
+	if r.URL.Query().Get("all") != "" {
+		dbUser, err := App.DB.GetUserByToken(token)
+		if err != nil {
+			if !IsNotFoundError(err) {
+				fmt.Fprintf(os.Stderr, "cannot fetch user by token from database: %v\n", err)
+				http.Error(w, "cannot log out", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err := App.DB.DeleteSessionsForUser(dbUser.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot delete sessions of user with ID %d from database: %v\n", dbUser.ID, err)
+			http.Error(w, "cannot log out", http.StatusInternalServerError)
+			return
+		}
+
+		App.Users.RemoveByID(dbUser.ID)
+		for _, conn := range connectionsOfUser(dbUser.ID) {
+			if conn.token != "" {
+				conn.close(websocket.CloseNormalClosure, "logged out")
+			}
+		}
+		return
+	}
+
+	if err := App.DB.DeleteSession(token); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot delete session from database: %v\n", err)
+		http.Error(w, "cannot log out", http.StatusInternalServerError)
+		return
+	}
+
+	App.Users.RemoveByTokenOrIP(token)
+	conns := Connections.Filter(func(conn *wsConn) bool {
+		return conn.token == token
+	})
+	for _, conn := range conns {
+		conn.close(websocket.CloseNormalClosure, "logged out")
+	}
+}
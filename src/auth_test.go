@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeRedditAccessToken is the access token given by newFakeReddit.
+const fakeRedditAccessToken = "fake-access-token"
+
+// newFakeReddit starts an OAuth 2.0 provider which works like reddit's, where every
+// account is called name and was created at createdAt, and sets up App to use it.
+func newFakeReddit(t *testing.T, name string, createdAt time.Time) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/access_token", func(w http.ResponseWriter, r *http.Request) {
+		key, secret, ok := r.BasicAuth()
+		if !ok || key != "test-key" || secret != "test-secret" {
+			http.Error(w, "bad client credentials", http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("code") != "test-code" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": fakeRedditAccessToken})
+	})
+	mux.HandleFunc("/api/v1/me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+fakeRedditAccessToken {
+			http.Error(w, "bad access token", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":        name,
+			"created_utc": createdAt.Unix(),
+		})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	setupTestApp(t, fmt.Sprintf(`
+oauth {
+  callbackBase: "http://pxls.test/auth"
+  reddit {
+    key: test-key
+    secret: test-secret
+    minAge: 1d
+    authURL: "%[1]s/api/v1/authorize"
+    tokenURL: "%[1]s/api/v1/access_token"
+    identityURL: "%[1]s/api/v1/me"
+  }
+}
+`, srv.URL))
+}
+
+// signin calls /signin/reddit and returns the state cookie set and the state sent to the provider.
+func signin(t *testing.T) (*http.Cookie, string) {
+	rec := httptest.NewRecorder()
+	handleSignin(rec, httptest.NewRequest("GET", "/signin/reddit", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signin responded with status %d: %s", rec.Code, rec.Body)
+	}
+
+	var res apiSignin
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("cannot decode signin response: %v", err)
+	}
+	authURL, err := url.Parse(res.URL)
+	if err != nil {
+		t.Fatalf("cannot parse auth URL: %v", err)
+	}
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == AuthStateCookie {
+			return c, authURL.Query().Get("state")
+		}
+	}
+	t.Fatalf("signin didn't set the %s cookie", AuthStateCookie)
+	return nil, ""
+}
+
+// callback calls /auth/reddit as the provider would redirect the user with the given state.
+func callback(stateCookie *http.Cookie, state string) *httptest.ResponseRecorder {
+	q := url.Values{"code": {"test-code"}, "state": {state}}
+	req := httptest.NewRequest("GET", "/auth/reddit?"+q.Encode(), nil)
+	req.AddCookie(stateCookie)
+
+	rec := httptest.NewRecorder()
+	handleAuthCallback(rec, req)
+	return rec
+}
+
+func getSessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "pxls-token" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestAuthCallbackSignsIn(t *testing.T) {
+	newFakeReddit(t, "alice", time.Now().Add(-30*24*time.Hour))
+	dbUser, err := App.DB.CreateUser("alice", UserLogin{"reddit", "alice"}, "192.0.2.1", "")
+	if err != nil {
+		t.Fatalf("cannot create user: %v", err)
+	}
+
+	rec := callback(signin(t))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback responded with status %d: %s", rec.Code, rec.Body)
+	}
+
+	c := getSessionCookie(rec)
+	if c == nil || c.Value == "" {
+		t.Fatal("callback didn't set the pxls-token cookie")
+	}
+	signedIn, err := App.DB.GetUserByToken(c.Value)
+	if err != nil {
+		t.Fatalf("cannot get user by session token: %v", err)
+	}
+	if signedIn.ID != dbUser.ID {
+		t.Errorf("signed in as user with ID %d, expected %d", signedIn.ID, dbUser.ID)
+	}
+}
+
+func TestAuthCallbackNewUserSignsUp(t *testing.T) {
+	newFakeReddit(t, "bob", time.Now().Add(-30*24*time.Hour))
+
+	rec := callback(signin(t))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback responded with status %d: %s", rec.Code, rec.Body)
+	}
+	if c := getSessionCookie(rec); c != nil {
+		t.Error("callback set the pxls-token cookie before signing up")
+	}
+
+	loc, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("cannot parse redirect location: %v", err)
+	}
+	params, _ := url.ParseQuery(loc.Fragment)
+	if params.Get("signup") != "true" || params.Get("token") == "" {
+		t.Errorf("expected to be redirected to sign up, got %s", loc)
+	}
+}
+
+func TestAuthCallbackStateMismatch(t *testing.T) {
+	newFakeReddit(t, "alice", time.Now().Add(-30*24*time.Hour))
+	if _, err := App.DB.CreateUser("alice", UserLogin{"reddit", "alice"}, "192.0.2.1", ""); err != nil {
+		t.Fatalf("cannot create user: %v", err)
+	}
+
+	stateCookie, _ := signin(t)
+	otherStateCookie, state := signin(t)
+	if stateCookie.Value == otherStateCookie.Value {
+		t.Fatal("signing in twice gave the same state")
+	}
+
+	rec := callback(stateCookie, state)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("callback with mismatched state responded with status %d, expected %d", rec.Code, http.StatusBadRequest)
+	}
+	if c := getSessionCookie(rec); c != nil {
+		t.Error("callback with mismatched state set the pxls-token cookie")
+	}
+}
+
+func TestAuthCallbackMinAge(t *testing.T) {
+	newFakeReddit(t, "alice", time.Now().Add(-time.Hour))
+	if _, err := App.DB.CreateUser("alice", UserLogin{"reddit", "alice"}, "192.0.2.1", ""); err != nil {
+		t.Fatalf("cannot create user: %v", err)
+	}
+
+	rec := callback(signin(t))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("callback for a too young account responded with status %d, expected %d", rec.Code, http.StatusForbidden)
+	}
+	if c := getSessionCookie(rec); c != nil {
+		t.Error("callback for a too young account set the pxls-token cookie")
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authHTTPClient is the client used to talk to OAuth providers.
+var authHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// authUserAgent is sent to OAuth providers, some of them (e.g. reddit) require one.
+const authUserAgent = "pxls-go/1.0"
+
+// oauthURLNames are the names of the provider endpoints which can be set in the config file.
+var oauthURLNames = []string{"requestTokenURL", "authURL", "tokenURL", "identityURL"}
+
+type oauthProviderConf struct {
+	key         string
+	secret      string
+	minAge      time.Duration
+	callbackURL string
+	// urls contains the provider endpoints set in the config file, stored by name (e.g. "tokenURL").
+	urls map[string]string
+}
+
+// getURL returns the endpoint with the given name set in the config file, or def if it isn't set.
+func (c oauthProviderConf) getURL(name, def string) string {
+	if u, ok := c.urls[name]; ok {
+		return u
+	}
+	return def
+}
+
+// oauthProviderMakers contains functions which create each
+// supported provider, stored by the provider's ID.
+var oauthProviderMakers = map[string]func(oauthProviderConf) OAuthProvider{
+	"reddit": func(c oauthProviderConf) OAuthProvider {
+		return &oauth2Provider{
+			oauthProviderConf: c,
+			id:                "reddit",
+			name:              "Reddit",
+			authURL:           c.getURL("authURL", "https://www.reddit.com/api/v1/authorize"),
+			tokenURL:          c.getURL("tokenURL", "https://www.reddit.com/api/v1/access_token"),
+			scopes:            []string{"identity"},
+			authParams:        url.Values{"duration": {"temporary"}},
+			useBasicAuth:      true,
+			identityURL:       c.getURL("identityURL", "https://oauth.reddit.com/api/v1/me"),
+			identify:          identifyReddit,
+		}
+	},
+	"google": func(c oauthProviderConf) OAuthProvider {
+		return &oauth2Provider{
+			oauthProviderConf: c,
+			id:                "google",
+			name:              "Google",
+			authURL:           c.getURL("authURL", "https://accounts.google.com/o/oauth2/v2/auth"),
+			tokenURL:          c.getURL("tokenURL", "https://oauth2.googleapis.com/token"),
+			scopes:            []string{"openid", "profile"},
+			identityURL:       c.getURL("identityURL", "https://www.googleapis.com/oauth2/v3/userinfo"),
+			identify:          identifyGoogle,
+		}
+	},
+	"discord": func(c oauthProviderConf) OAuthProvider {
+		return &oauth2Provider{
+			oauthProviderConf: c,
+			id:                "discord",
+			name:              "Discord",
+			authURL:           c.getURL("authURL", "https://discordapp.com/api/oauth2/authorize"),
+			tokenURL:          c.getURL("tokenURL", "https://discordapp.com/api/oauth2/token"),
+			scopes:            []string{"identify"},
+			identityURL:       c.getURL("identityURL", "https://discordapp.com/api/users/@me"),
+			identify:          identifyDiscord,
+		}
+	},
+	"vk": func(c oauthProviderConf) OAuthProvider {
+		return &oauth2Provider{
+			oauthProviderConf: c,
+			id:                "vk",
+			name:              "VK",
+			authURL:           c.getURL("authURL", "https://oauth.vk.com/authorize"),
+			tokenURL:          c.getURL("tokenURL", "https://oauth.vk.com/access_token"),
+			identityURL:       c.getURL("identityURL", "https://api.vk.com/method/users.get"),
+			identify:          identifyVK,
+		}
+	},
+	"tumblr": func(c oauthProviderConf) OAuthProvider {
+		return &oauth1Provider{
+			oauthProviderConf: c,
+			id:                "tumblr",
+			name:              "Tumblr",
+			requestTokenURL:   c.getURL("requestTokenURL", "https://www.tumblr.com/oauth/request_token"),
+			authURL:           c.getURL("authURL", "https://www.tumblr.com/oauth/authorize"),
+			accessTokenURL:    c.getURL("tokenURL", "https://www.tumblr.com/oauth/access_token"),
+			identityURL:       c.getURL("identityURL", "https://api.tumblr.com/v2/user/info"),
+			identify:          identifyTumblr,
+			pending:           make(map[string]oauth1PendingToken),
+		}
+	},
+}
+
+// getJSON makes an authorized GET request and decodes the JSON response into v.
+func getJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("User-Agent", authUserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := authHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %s", req.URL.Host, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+/// OAuth 2.0
+
+// oauth2Provider is a provider which uses the OAuth 2.0 authorization code flow.
+type oauth2Provider struct {
+	oauthProviderConf
+	id           string
+	name         string
+	authURL      string
+	tokenURL     string
+	scopes       []string
+	authParams   url.Values
+	identityURL  string
+	useBasicAuth bool
+	identify     func(p *oauth2Provider, accessToken string) (*OAuthIdentity, error)
+}
+
+func (p *oauth2Provider) ID() string            { return p.id }
+func (p *oauth2Provider) Name() string          { return p.name }
+func (p *oauth2Provider) MinAge() time.Duration { return p.minAge }
+
+func (p *oauth2Provider) AuthURL(state string) (string, error) {
+	q := url.Values{
+		"client_id":     {p.key},
+		"response_type": {"code"},
+		"redirect_uri":  {p.callbackURL},
+		"state":         {state},
+	}
+	if len(p.scopes) > 0 {
+		q.Set("scope", strings.Join(p.scopes, " "))
+	}
+	for k, v := range p.authParams {
+		q[k] = v
+	}
+
+	return p.authURL + "?" + q.Encode(), nil
+}
+
+func (p *oauth2Provider) Identify(r *http.Request, state string) (*OAuthIdentity, error) {
+	q := r.URL.Query()
+	if state == "" || q.Get("state") != state {
+		return nil, fmt.Errorf("state mismatch")
+	}
+
+	code := q.Get("code")
+	if code == "" {
+		return nil, fmt.Errorf("no authorization code")
+	}
+
+	accessToken, err := p.exchangeCode(code)
+	if err != nil {
+		return nil, fmt.Errorf("cannot exchange authorization code: %v", err)
+	}
+
+	return p.identify(p, accessToken)
+}
+
+func (p *oauth2Provider) exchangeCode(code string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.callbackURL},
+	}
+	if !p.useBasicAuth {
+		form.Set("client_id", p.key)
+		form.Set("client_secret", p.secret)
+	}
+
+	req, err := http.NewRequest("POST", p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.useBasicAuth {
+		req.SetBasicAuth(p.key, p.secret)
+	}
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := getJSON(req, &res); err != nil {
+		return "", err
+	}
+	if res.AccessToken == "" {
+		return "", fmt.Errorf("no access token in response (error: %s)", res.Error)
+	}
+
+	return res.AccessToken, nil
+}
+
+func bearerRequest(uri, accessToken string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	return req, nil
+}
+
+func identifyReddit(p *oauth2Provider, accessToken string) (*OAuthIdentity, error) {
+	req, err := bearerRequest(p.identityURL, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Name       string  `json:"name"`
+		CreatedUTC float64 `json:"created_utc"`
+	}
+	if err := getJSON(req, &res); err != nil {
+		return nil, err
+	}
+
+	createdAt := time.Unix(int64(res.CreatedUTC), 0)
+	return &OAuthIdentity{res.Name, res.Name, &createdAt}, nil
+}
+
+func identifyGoogle(p *oauth2Provider, accessToken string) (*OAuthIdentity, error) {
+	req, err := bearerRequest(p.identityURL, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Sub  string `json:"sub"`
+		Name string `json:"name"`
+	}
+	if err := getJSON(req, &res); err != nil {
+		return nil, err
+	}
+
+	return &OAuthIdentity{res.Sub, res.Name, nil}, nil
+}
+
+// discordEpoch is the first second of 2015 in milliseconds, used by Discord's snowflake IDs.
+const discordEpoch = 1420070400000
+
+func identifyDiscord(p *oauth2Provider, accessToken string) (*OAuthIdentity, error) {
+	req, err := bearerRequest(p.identityURL, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}
+	if err := getJSON(req, &res); err != nil {
+		return nil, err
+	}
+
+	identity := &OAuthIdentity{ID: res.ID, Name: res.Username}
+	if snowflake, err := strconv.ParseUint(res.ID, 10, 64); err == nil {
+		ms := int64(snowflake>>22) + discordEpoch
+		createdAt := time.Unix(0, ms*int64(time.Millisecond))
+		identity.CreatedAt = &createdAt
+	}
+	return identity, nil
+}
+
+func identifyVK(p *oauth2Provider, accessToken string) (*OAuthIdentity, error) {
+	q := url.Values{
+		"access_token": {accessToken},
+		"v":            {"5.101"},
+	}
+	req, err := http.NewRequest("GET", p.identityURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Response []struct {
+			ID        int64  `json:"id"`
+			FirstName string `json:"first_name"`
+		} `json:"response"`
+	}
+	if err := getJSON(req, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Response) == 0 {
+		return nil, fmt.Errorf("no user in response")
+	}
+
+	u := res.Response[0]
+	return &OAuthIdentity{strconv.FormatInt(u.ID, 10), u.FirstName, nil}, nil
+}
+
+/// OAuth 1.0a
+
+type oauth1PendingToken struct {
+	secret  string
+	expires time.Time
+}
+
+// oauth1Provider is a provider which uses the OAuth 1.0a flow.
+type oauth1Provider struct {
+	oauthProviderConf
+	id              string
+	name            string
+	requestTokenURL string
+	authURL         string
+	accessTokenURL  string
+	identityURL     string
+	identify        func(p *oauth1Provider, token, tokenSecret string) (*OAuthIdentity, error)
+
+	// pending contains request token secrets stored by request token,
+	// waiting for the user to come back from authorizing.
+	mu      sync.Mutex
+	pending map[string]oauth1PendingToken
+}
+
+func (p *oauth1Provider) ID() string            { return p.id }
+func (p *oauth1Provider) Name() string          { return p.name }
+func (p *oauth1Provider) MinAge() time.Duration { return p.minAge }
+
+// AuthURL ignores the state: the request token serves the same purpose.
+func (p *oauth1Provider) AuthURL(state string) (string, error) {
+	res, err := p.signedForm("POST", p.requestTokenURL, "", map[string]string{
+		"oauth_callback": p.callbackURL,
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot get request token: %v", err)
+	}
+
+	token := res.Get("oauth_token")
+	if token == "" {
+		return "", fmt.Errorf("no request token in response")
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	for t, pt := range p.pending {
+		if now.After(pt.expires) {
+			delete(p.pending, t)
+		}
+	}
+	p.pending[token] = oauth1PendingToken{res.Get("oauth_token_secret"), now.Add(10 * time.Minute)}
+	p.mu.Unlock()
+
+	return p.authURL + "?" + url.Values{"oauth_token": {token}}.Encode(), nil
+}
+
+func (p *oauth1Provider) Identify(r *http.Request, _ string) (*OAuthIdentity, error) {
+	q := r.URL.Query()
+	token := q.Get("oauth_token")
+
+	p.mu.Lock()
+	pt, ok := p.pending[token]
+	delete(p.pending, token)
+	p.mu.Unlock()
+	if !ok || time.Now().After(pt.expires) {
+		return nil, fmt.Errorf("unknown or expired request token")
+	}
+
+	res, err := p.signedForm("POST", p.accessTokenURL, pt.secret, map[string]string{
+		"oauth_token":    token,
+		"oauth_verifier": q.Get("oauth_verifier"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get access token: %v", err)
+	}
+
+	return p.identify(p, res.Get("oauth_token"), res.Get("oauth_token_secret"))
+}
+
+// oauth1Escape percent-encodes s as described in RFC 5849, section 3.6.
+func oauth1Escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// signedRequest creates a request signed with HMAC-SHA1.
+func (p *oauth1Provider) signedRequest(method, uri, tokenSecret string, params map[string]string) (*http.Request, error) {
+	nonce, err := GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     p.key,
+		"oauth_nonce":            nonce,
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_version":          "1.0",
+	}
+	for k, v := range params {
+		oauthParams[k] = v
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	/// Build signature base string
+	var pairs []string
+	for k, v := range oauthParams {
+		pairs = append(pairs, oauth1Escape(k)+"="+oauth1Escape(v))
+	}
+	for k, vs := range u.Query() {
+		for _, v := range vs {
+			pairs = append(pairs, oauth1Escape(k)+"="+oauth1Escape(v))
+		}
+	}
+	sort.Strings(pairs)
+
+	baseURL := *u
+	baseURL.RawQuery = ""
+	base := method + "&" + oauth1Escape(baseURL.String()) + "&" + oauth1Escape(strings.Join(pairs, "&"))
+
+	mac := hmac.New(sha1.New, []byte(oauth1Escape(p.secret)+"&"+oauth1Escape(tokenSecret)))
+	mac.Write([]byte(base))
+	oauthParams["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	/// Build Authorization header
+	var header []string
+	for k, v := range oauthParams {
+		header = append(header, fmt.Sprintf("%s=\"%s\"", oauth1Escape(k), oauth1Escape(v)))
+	}
+	sort.Strings(header)
+
+	req, err := http.NewRequest(method, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+	req.Header.Set("User-Agent", authUserAgent)
+	return req, nil
+}
+
+// signedForm makes a signed request and parses the form-encoded response.
+func (p *oauth1Provider) signedForm(method, uri, tokenSecret string, params map[string]string) (url.Values, error) {
+	req, err := p.signedRequest(method, uri, tokenSecret, params)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := authHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with status %s", req.URL.Host, res.Status)
+	}
+
+	return url.ParseQuery(string(body))
+}
+
+func identifyTumblr(p *oauth1Provider, token, tokenSecret string) (*OAuthIdentity, error) {
+	req, err := p.signedRequest("GET", p.identityURL, tokenSecret, map[string]string{
+		"oauth_token": token,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Response struct {
+			User struct {
+				Name string `json:"name"`
+			} `json:"user"`
+		} `json:"response"`
+	}
+	if err := getJSON(req, &res); err != nil {
+		return nil, err
+	}
+
+	name := res.Response.User.Name
+	return &OAuthIdentity{name, name, nil}, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-akka/configuration"
+)
+
+const (
+	// BackupsDir is the name of the directory under server.storage backups are placed in
+	BackupsDir = "backups"
+
+	backupPrefix     = "board-"
+	backupSuffix     = ".dat"
+	backupTimeLayout = "20060102-150405"
+)
+
+// backupFileName returns the name of the backup made at t.
+func backupFileName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupTimeLayout) + backupSuffix
+}
+
+// parseBackupFileName returns the time the backup with the given name was made at.
+func parseBackupFileName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeLayout, name[len(backupPrefix):len(name)-len(backupSuffix)])
+	return t, err == nil
+}
+
+// getBackupsPath returns the path of the backups directory.
+func getBackupsPath(conf *configuration.Config) string {
+	return getStoragePath(conf, BackupsDir)
+}
+
+// saveBackup writes the contents of the board into a new backup file in dir
+// and returns the version of the board saved.
+func saveBackup(c *Canvas, dir string, now time.Time) (uint64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	return saveCanvas(c, filepath.Join(dir, backupFileName(now)))
+}
+
+// selectBackupsToKeep returns which of the backups made at the given times are kept at now:
+// every backup from the last day, one per hour from the last week, and one per day before that.
+// The newest backup of each hour or day is the one kept.
+func selectBackupsToKeep(times []time.Time, now time.Time) map[time.Time]bool {
+	sorted := make([]time.Time, len(times))
+	copy(sorted, times)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].After(sorted[j]) })
+
+	keep := make(map[time.Time]bool)
+	seen := make(map[string]bool)
+	for _, t := range sorted {
+		var bucket string
+		switch age := now.Sub(t); {
+		case age <= 24*time.Hour:
+			keep[t] = true
+			continue
+		case age <= 7*24*time.Hour:
+			bucket = t.UTC().Format("2006010215")
+		default:
+			bucket = t.UTC().Format("20060102")
+		}
+
+		if !seen[bucket] {
+			seen[bucket] = true
+			keep[t] = true
+		}
+	}
+	return keep
+}
+
+// pruneBackups deletes the backups in dir that aren't kept by the retention rules.
+func pruneBackups(dir string, now time.Time) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	byTime := make(map[time.Time]string)
+	var times []time.Time
+	for _, fi := range files {
+		if t, ok := parseBackupFileName(fi.Name()); ok && !fi.IsDir() {
+			byTime[t] = fi.Name()
+			times = append(times, t)
+		}
+	}
+
+	keep := selectBackupsToKeep(times, now)
+	for _, t := range times {
+		if keep[t] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, byTime[t])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupCanvasEvery saves a backup of the canvas and prunes old ones every d time,
+// if the board changed since the last backup.
+func backupCanvasEvery(c *Canvas, d time.Duration) {
+	var saved = c.Version()
+	for range time.Tick(d) {
+		if c.Version() == saved {
+			continue
+		}
+
+		var (
+			dir = getBackupsPath(&App.Conf)
+			now = time.Now()
+		)
+
+		version, err := saveBackup(c, dir, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "save canvas backup err: %v\n", err)
+			continue
+		}
+		saved = version
+
+		if err := pruneBackups(dir, now); err != nil {
+			fmt.Fprintf(os.Stderr, "prune canvas backups err: %v\n", err)
+		}
+	}
+}
+
+// restoreBackup writes the contents of a backup to the canvas board. The backup can be
+// either the name of a file in the backups directory or the path to any file.
+func restoreBackup(c *Canvas, conf *configuration.Config, p Palette, name string, migrate bool) error {
+	path := name
+	if filepath.Base(name) == name {
+		path = filepath.Join(getBackupsPath(conf), name)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	return populateCanvasFromFile(c, path, conf, p, migrate)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectBackupsToKeep(t *testing.T) {
+	var (
+		now  = time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+		day  = 24 * time.Hour
+		week = 7 * day
+	)
+
+	tests := []struct {
+		name    string
+		ages    []time.Duration
+		dropped []time.Duration
+	}{
+		{
+			"every backup of the last day",
+			[]time.Duration{time.Minute, 2 * time.Minute, 12 * time.Hour, day},
+			nil,
+		},
+		{
+			"newest of each hour after the last day",
+			[]time.Duration{day + time.Minute, day + 30*time.Minute, day + time.Hour + 10*time.Minute, day + time.Hour + 20*time.Minute},
+			[]time.Duration{day + 30*time.Minute, day + time.Hour + 20*time.Minute},
+		},
+		{
+			"newest of each day after the last week",
+			[]time.Duration{week + 10*time.Minute, week + 4*time.Hour, week + 11*time.Hour, week + 13*time.Hour},
+			[]time.Duration{week + 4*time.Hour, week + 11*time.Hour},
+		},
+		{
+			"hourly up to the last week",
+			[]time.Duration{week, week + 10*time.Minute, week + 20*time.Minute},
+			[]time.Duration{week + 20*time.Minute},
+		},
+		{
+			"unsorted",
+			[]time.Duration{day + 30*time.Minute, week + 11*time.Hour, day + time.Minute, week + 4*time.Hour},
+			[]time.Duration{day + 30*time.Minute, week + 11*time.Hour},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			times := make([]time.Time, len(test.ages))
+			for i, age := range test.ages {
+				times[i] = now.Add(-age)
+			}
+			dropped := make(map[time.Time]bool)
+			for _, age := range test.dropped {
+				dropped[now.Add(-age)] = true
+			}
+
+			keep := selectBackupsToKeep(times, now)
+			for _, bt := range times {
+				if keep[bt] == dropped[bt] {
+					t.Errorf("backup from %v ago kept: %v, expected %v", now.Sub(bt), keep[bt], !dropped[bt])
+				}
+			}
+		})
+	}
+}
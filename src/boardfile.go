@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/go-akka/configuration"
+)
+
+// Board files start with BoardFileMagic followed by a header describing the canvas
+// and the payload: the canvas board, optionally compressed.
+// Files without it are legacy board files, which only hold the canvas board.
+const (
+	// BoardFileMagic identifies board files with a header
+	BoardFileMagic = "PXLSBRD"
+	// BoardFileVersion is the version of the board file format written
+	BoardFileVersion byte = 1
+)
+
+// BoardCompression is how the payload of a board file is compressed.
+type BoardCompression byte
+
+const (
+	// NoBoardCompression stores the canvas board as is
+	NoBoardCompression BoardCompression = iota
+	// GzipBoardCompression stores the canvas board compressed with gzip
+	GzipBoardCompression
+	// ZstdBoardCompression is reserved for boards compressed with zstd, which isn't supported yet
+	ZstdBoardCompression
+)
+
+// BoardMeta describes the canvas a board belongs to.
+type BoardMeta struct {
+	Width       uint32
+	Height      uint32
+	PaletteHash uint32
+	CanvasCode  string
+}
+
+// BoardHeader is the header of a board file.
+type BoardHeader struct {
+	BoardMeta
+	Version     byte
+	Compression BoardCompression
+	// Checksum is the CRC-32 of the uncompressed canvas board
+	Checksum uint32
+}
+
+// hashPalette returns a hash of the colors of the palette.
+func hashPalette(p Palette) uint32 {
+	b := make([]byte, 4*len(p))
+	for i, c := range p {
+		binary.BigEndian.PutUint32(b[4*i:], uint32(c))
+	}
+	return crc32.ChecksumIEEE(b)
+}
+
+// makeBoardMeta describes the canvas with the given palette and the canvas code in the config file.
+func makeBoardMeta(conf *configuration.Config, c *Canvas, p Palette) BoardMeta {
+	return BoardMeta{
+		Width:       uint32(c.Width),
+		Height:      uint32(c.Height),
+		PaletteHash: hashPalette(p),
+		CanvasCode:  conf.GetString("canvascode"),
+	}
+}
+
+// getBoardCompression returns the compression set in board.compression.
+func getBoardCompression(conf *configuration.Config) (BoardCompression, error) {
+	switch s := conf.GetString("board.compression", "gzip"); s {
+	case "none":
+		return NoBoardCompression, nil
+	case "gzip":
+		return GzipBoardCompression, nil
+	default:
+		return 0, fmt.Errorf("unsupported board compression %s", s)
+	}
+}
+
+// writeBoardFile writes the board, with a header holding meta, to w.
+func writeBoardFile(w io.Writer, board []byte, meta BoardMeta, compression BoardCompression) error {
+	if len(meta.CanvasCode) > 0xFFFF {
+		return fmt.Errorf("canvas code is too long")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(BoardFileMagic)
+	buf.WriteByte(BoardFileVersion)
+	buf.WriteByte(byte(compression))
+	binary.Write(&buf, binary.BigEndian, meta.Width)
+	binary.Write(&buf, binary.BigEndian, meta.Height)
+	binary.Write(&buf, binary.BigEndian, meta.PaletteHash)
+	binary.Write(&buf, binary.BigEndian, uint16(len(meta.CanvasCode)))
+	buf.WriteString(meta.CanvasCode)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(board))
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	switch compression {
+	case NoBoardCompression:
+		_, err := w.Write(board)
+		return err
+	case GzipBoardCompression:
+		zw := gzip.NewWriter(w)
+		if _, err := zw.Write(board); err != nil {
+			return err
+		}
+		return zw.Close()
+	default:
+		return fmt.Errorf("unsupported board compression %d", compression)
+	}
+}
+
+// readBoardFile reads a board file. The header is nil if it's a legacy board file.
+func readBoardFile(r io.Reader) (*BoardHeader, []byte, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.HasPrefix(b, []byte(BoardFileMagic)) {
+		return nil, b, nil
+	}
+
+	var (
+		h   BoardHeader
+		buf = bytes.NewReader(b[len(BoardFileMagic):])
+	)
+	if err := binary.Read(buf, binary.BigEndian, &h.Version); err != nil {
+		return nil, nil, fmt.Errorf("cannot read board file header: %v", err)
+	}
+	if h.Version != BoardFileVersion {
+		return nil, nil, fmt.Errorf("unsupported board file version %d", h.Version)
+	}
+
+	var codeLen uint16
+	for _, v := range []interface{}{&h.Compression, &h.Width, &h.Height, &h.PaletteHash, &codeLen} {
+		if err := binary.Read(buf, binary.BigEndian, v); err != nil {
+			return nil, nil, fmt.Errorf("cannot read board file header: %v", err)
+		}
+	}
+	code := make([]byte, codeLen)
+	if _, err := io.ReadFull(buf, code); err != nil {
+		return nil, nil, fmt.Errorf("cannot read board file header: %v", err)
+	}
+	h.CanvasCode = string(code)
+	if err := binary.Read(buf, binary.BigEndian, &h.Checksum); err != nil {
+		return nil, nil, fmt.Errorf("cannot read board file header: %v", err)
+	}
+
+	var board []byte
+	switch h.Compression {
+	case NoBoardCompression:
+		board, err = ioutil.ReadAll(buf)
+	case GzipBoardCompression:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(buf); err == nil {
+			board, err = ioutil.ReadAll(zr)
+		}
+	case ZstdBoardCompression:
+		err = fmt.Errorf("zstd compressed boards are not supported")
+	default:
+		err = fmt.Errorf("unknown board compression %d", h.Compression)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read board file payload: %v", err)
+	}
+
+	if uint32(len(board)) != h.Width*h.Height {
+		return nil, nil, fmt.Errorf("board file payload has %d pixels, but header says %dx%d", len(board), h.Width, h.Height)
+	}
+	if sum := crc32.ChecksumIEEE(board); sum != h.Checksum {
+		return nil, nil, fmt.Errorf("board file checksum mismatch: expected %08x, got %08x", h.Checksum, sum)
+	}
+
+	return &h, board, nil
+}
+
+// checkBoardMeta returns an error describing how the board file's canvas differs from meta, if it does.
+func checkBoardMeta(h *BoardHeader, meta BoardMeta) error {
+	switch {
+	case h.Width != meta.Width || h.Height != meta.Height:
+		return fmt.Errorf("board is %dx%d, but canvas is %dx%d", h.Width, h.Height, meta.Width, meta.Height)
+	case h.PaletteHash != meta.PaletteHash:
+		return fmt.Errorf("board was saved with a different palette")
+	case h.CanvasCode != meta.CanvasCode:
+		return fmt.Errorf("board belongs to canvas %q, but canvas code is %q", h.CanvasCode, meta.CanvasCode)
+	}
+	return nil
+}
+
+// migrateBoard copies the part of a w*h board that fits into the canvas,
+// leaving the rest of the canvas untouched. Colors outside of the palette
+// are replaced with defaultColor.
+func migrateBoard(c *Canvas, board []byte, w, h uint, paletteSize int, defaultColor byte) {
+	for y := uint(0); y < h && y < c.Height; y++ {
+		for x := uint(0); x < w && x < c.Width; x++ {
+			color := board[x+y*w]
+			if int(color) >= paletteSize {
+				color = defaultColor
+			}
+			c.SetPixelColor(x, y, color)
+		}
+	}
+}
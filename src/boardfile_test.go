@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testBoardMeta = BoardMeta{Width: 4, Height: 3, PaletteHash: 0xCAFE, CanvasCode: "test"}
+
+// writeTestBoardFile writes a board file holding board with testBoardMeta and returns its contents.
+func writeTestBoardFile(t *testing.T, board []byte, compression BoardCompression) []byte {
+	var buf bytes.Buffer
+	if err := writeBoardFile(&buf, board, testBoardMeta, compression); err != nil {
+		t.Fatalf("cannot write board file: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBoardFileRoundTrip(t *testing.T) {
+	board := []byte{0, 1, 2, 3, 3, 2, 1, 0, 1, 1, 2, 2}
+
+	for _, compression := range []BoardCompression{NoBoardCompression, GzipBoardCompression} {
+		h, got, err := readBoardFile(bytes.NewReader(writeTestBoardFile(t, board, compression)))
+		if err != nil {
+			t.Fatalf("cannot read board file with compression %d: %v", compression, err)
+		}
+		if h == nil {
+			t.Fatalf("board file with compression %d was read as a legacy one", compression)
+		}
+		if h.BoardMeta != testBoardMeta || h.Version != BoardFileVersion || h.Compression != compression {
+			t.Errorf("board file with compression %d has header %+v", compression, *h)
+		}
+		if !bytes.Equal(got, board) {
+			t.Errorf("board file with compression %d has board %v, expected %v", compression, got, board)
+		}
+	}
+}
+
+func TestReadBoardFileChecksumMismatch(t *testing.T) {
+	b := writeTestBoardFile(t, make([]byte, 12), NoBoardCompression)
+	b[len(b)-1] = 1
+
+	_, _, err := readBoardFile(bytes.NewReader(b))
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("reading a board file with a changed pixel returned %v, expected a checksum mismatch", err)
+	}
+}
+
+func TestReadBoardFileVersion(t *testing.T) {
+	b := writeTestBoardFile(t, make([]byte, 12), NoBoardCompression)
+	b[len(BoardFileMagic)] = BoardFileVersion + 1
+
+	if _, _, err := readBoardFile(bytes.NewReader(b)); err == nil {
+		t.Error("reading a board file of an unknown version succeeded")
+	}
+}
+
+func TestReadBoardFileMagic(t *testing.T) {
+	b := writeTestBoardFile(t, make([]byte, 12), NoBoardCompression)
+	b[0] = 'X'
+
+	// Note(netux): without the magic, it's read as a legacy board file holding only the board
+	h, got, err := readBoardFile(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("cannot read board file without magic: %v", err)
+	}
+	if h != nil || !bytes.Equal(got, b) {
+		t.Errorf("board file without magic wasn't read as a legacy one: header %v", h)
+	}
+}
+
+func TestCheckBoardMeta(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(m *BoardMeta)
+		ok     bool
+	}{
+		{"same canvas", func(*BoardMeta) {}, true},
+		{"different width", func(m *BoardMeta) { m.Width++ }, false},
+		{"different height", func(m *BoardMeta) { m.Height++ }, false},
+		{"different palette", func(m *BoardMeta) { m.PaletteHash++ }, false},
+		{"different canvas code", func(m *BoardMeta) { m.CanvasCode = "other" }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta := testBoardMeta
+			test.change(&meta)
+			if err := checkBoardMeta(&BoardHeader{BoardMeta: testBoardMeta}, meta); (err == nil) != test.ok {
+				t.Errorf("checkBoardMeta() = %v, expected ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+// writeTestFile writes b into a file in a temporary directory and returns its path.
+func writeTestFile(t *testing.T, b []byte) string {
+	path := filepath.Join(t.TempDir(), CanvasBoardFile)
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatalf("cannot write test file: %v", err)
+	}
+	return path
+}
+
+func TestPopulateCanvasFromLegacyFile(t *testing.T) {
+	setupTestApp(t, "")
+	var w, h = App.Canvas.Width, App.Canvas.Height
+
+	board := make([]byte, w*h)
+	board[1] = 2
+	path := writeTestFile(t, board)
+
+	c := NewCanvas(w, h, 0)
+	if err := populateCanvasFromFile(c, path, &App.Conf, App.Palette, false); err != nil {
+		t.Fatalf("cannot populate canvas from legacy board file: %v", err)
+	}
+	if color := c.GetPixelColorIndex(1, 0); color != 2 {
+		t.Errorf("pixel has color %d after loading the legacy board file, expected 2", color)
+	}
+}
+
+func TestPopulateCanvasMigratesLegacyFile(t *testing.T) {
+	setupTestApp(t, "")
+	var w = App.Canvas.Width
+
+	// Note(netux): half as tall as the canvas, with a color outside of the palette
+	board := make([]byte, w*App.Canvas.Height/2)
+	board[0] = 3
+	board[1] = byte(len(App.Palette))
+	path := writeTestFile(t, board)
+
+	c := NewCanvas(w, App.Canvas.Height, 1)
+	if err := populateCanvasFromFile(c, path, &App.Conf, App.Palette, false); err == nil {
+		t.Fatal("legacy board file of a different size was loaded without migrating")
+	}
+
+	if err := populateCanvasFromFile(c, path, &App.Conf, App.Palette, true); err != nil {
+		t.Fatalf("cannot migrate legacy board file: %v", err)
+	}
+	for _, p := range []struct {
+		x, y  uint
+		color byte
+	}{
+		{0, 0, 3},
+		{1, 0, 0},
+		{2, 0, 0},
+		{0, App.Canvas.Height/2 - 1, 0},
+		{0, App.Canvas.Height / 2, 1},
+	} {
+		if color := c.GetPixelColorIndex(p.x, p.y); color != p.color {
+			t.Errorf("pixel at (%d, %d) has color %d after migrating, expected %d", p.x, p.y, color, p.color)
+		}
+	}
+}
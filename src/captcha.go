@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/go-akka/configuration"
+)
+
+// CaptchaVerifier verifies captcha tokens sent by clients.
+type CaptchaVerifier interface {
+	// Verify returns whenever the token is valid for the given IP.
+	Verify(token, ip string) (bool, error)
+}
+
+// RecaptchaVerifyURL is the URL reCAPTCHA tokens are verified against.
+const RecaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+
+// RecaptchaVerifier verifies tokens through Google's reCAPTCHA API.
+type RecaptchaVerifier struct {
+	Secret    string
+	VerifyURL string
+	Client    *http.Client
+}
+
+// Verify returns whenever reCAPTCHA considers the token valid.
+func (v *RecaptchaVerifier) Verify(token, ip string) (bool, error) {
+	res, err := v.Client.PostForm(v.VerifyURL, url.Values{
+		"secret":   {v.Secret},
+		"response": {token},
+		"remoteip": {ip},
+	})
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("reCAPTCHA responded with status %s", res.Status)
+	}
+
+	var body struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return false, err
+	}
+	return body.Success, nil
+}
+
+// MakeRecaptchaVerifier creates a RecaptchaVerifier with the given secret.
+func MakeRecaptchaVerifier(secret string) *RecaptchaVerifier {
+	return &RecaptchaVerifier{
+		Secret:    secret,
+		VerifyURL: RecaptchaVerifyURL,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// LocalCaptchaVerifier accepts a single, fixed, token.
+// It's meant for tests, where reCAPTCHA can't be reached.
+type LocalCaptchaVerifier struct {
+	Token string
+}
+
+// Verify returns whenever the token is the accepted one.
+func (v *LocalCaptchaVerifier) Verify(token, _ string) (bool, error) {
+	return v.Token != "" && token == v.Token, nil
+}
+
+// makeCaptchaVerifierFromConf creates a reCAPTCHA verifier if the
+// captcha block of the config file has a key and secret, or returns nil otherwise.
+func makeCaptchaVerifierFromConf(conf *configuration.Config) CaptchaVerifier {
+	if conf.GetString("captcha.key") == "" || conf.GetString("captcha.secret") == "" {
+		return nil
+	}
+	return MakeRecaptchaVerifier(conf.GetString("captcha.secret"))
+}
+
+// isCaptchaEnabled returns whenever placing can require a captcha.
+func isCaptchaEnabled() bool {
+	return App.Captcha != nil
+}
+
+// getCaptchaKey returns the site key the client shows the captcha with,
+// or an empty string if captchas are disabled.
+func getCaptchaKey() string {
+	if !isCaptchaEnabled() {
+		return ""
+	}
+	return App.Conf.GetString("captcha.key")
+}
+
+// needsCaptcha returns whenever the user has to solve a captcha before placing.
+// Users are asked roughly once every captcha.threshold placements, until
+// they have placed captcha.maxPixels (if set) pixels.
+func needsCaptcha(u *User) bool {
+	if !isCaptchaEnabled() {
+		return false
+	}
+
+	if maxPixels := uint64(App.Conf.GetInt64("captcha.maxPixels")); maxPixels > 0 {
+		var state = App.Users.Snapshot(u.DBUser)
+		var count = state.PixelCount
+		if App.Conf.GetBoolean("captcha.allTime") {
+			count = state.PixelCountAlltime
+		}
+		if count >= maxPixels {
+			return false
+		}
+	}
+
+	threshold := int(App.Conf.GetInt32("captcha.threshold"))
+	return threshold <= 1 || rand.Intn(threshold) == 0
+}
+
+const wsCaptchaRequiredType = "captcha_required"
+const wsCaptchaType = "captcha"
+const wsCaptchaStatusType = "captcha_status"
+
+type wsCaptchaReq struct {
+	wsMessage
+	Token string `json:"token"`
+}
+
+type wsCaptchaStatus struct {
+	wsMessage
+	Success bool `json:"success"`
+}
+
+func handleCaptcha(conn *wsConn, captchaMsg wsCaptchaReq) {
+	if conn.user == nil || conn.pendingPixel == nil || !isCaptchaEnabled() {
+		return
+	}
+
+	pixelMsg := *conn.pendingPixel
+	conn.pendingPixel = nil
+
+	ok, err := App.Captcha.Verify(captchaMsg.Token, conn.ip)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot verify captcha of user with ID %d: %v\n", conn.user.ID, err)
+	}
+
+	conn.queue(wsCaptchaStatus{withType(wsCaptchaStatusType), ok})
+	if !ok {
+		return
+	}
+
+	if canPlacePixel(conn, pixelMsg) {
+		placePixel(conn, pixelMsg)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNeedsCaptcha(t *testing.T) {
+	tests := []struct {
+		name              string
+		conf              string
+		disabled          bool
+		pixelCount        uint64
+		pixelCountAlltime uint64
+		expected          bool
+	}{
+		{"disabled", "captcha { threshold: 1 }", true, 0, 0, false},
+		{"threshold of 1", "captcha { threshold: 1 }", false, 0, 0, true},
+		{"below maxPixels", "captcha { threshold: 1, maxPixels: 10, allTime: false }", false, 9, 100, true},
+		{"reached maxPixels", "captcha { threshold: 1, maxPixels: 10, allTime: false }", false, 10, 10, false},
+		{"below maxPixels all time", "captcha { threshold: 1, maxPixels: 10, allTime: true }", false, 0, 9, true},
+		{"reached maxPixels all time", "captcha { threshold: 1, maxPixels: 10, allTime: true }", false, 0, 10, false},
+		{"no maxPixels", "captcha { threshold: 1, maxPixels: 0 }", false, 1000, 1000, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupTestApp(t, test.conf)
+			if !test.disabled {
+				App.Captcha = &LocalCaptchaVerifier{"test-token"}
+			}
+
+			u := MakeUser(&DBUser{PixelCount: test.pixelCount, PixelCountAlltime: test.pixelCountAlltime})
+			if got := needsCaptcha(u); got != test.expected {
+				t.Errorf("needsCaptcha() = %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestNeedsCaptchaThreshold(t *testing.T) {
+	setupTestApp(t, "captcha { threshold: 2 }")
+	App.Captcha = &LocalCaptchaVerifier{"test-token"}
+
+	u := MakeUser(&DBUser{})
+	var needed int
+	for i := 0; i < 1000; i++ {
+		if needsCaptcha(u) {
+			needed++
+		}
+	}
+	// Note(netux): a captcha is needed half of the time, these bounds are very unlikely to be missed
+	if needed < 350 || needed > 650 {
+		t.Errorf("captcha was needed %d out of 1000 times with a threshold of 2", needed)
+	}
+}
+
+// setupCaptchaTest creates an user with a pixel available and a connection of them
+// with a pixel waiting for the captcha to be solved.
+func setupCaptchaTest(t *testing.T) (*wsConn, wsPixelReq) {
+	setupTestApp(t, "captcha { threshold: 1 }")
+	App.Captcha = &LocalCaptchaVerifier{"test-token"}
+
+	u := newTestUser(t, "alice")
+	u.PixelStacker.Restore(1, nil)
+	// Note(netux): connections resume the timer when they open
+	u.PixelStacker.ResumeTimer()
+	conn := newTestConn(t, u)
+
+	pixelMsg := wsPixelReq{withType(wsPixelType), wsPixel{PosX: 1, PosY: 2, ColorIdx: 3}}
+	handlePixel(conn, pixelMsg)
+	if conn.pendingPixel == nil {
+		t.Fatal("pixel wasn't kept waiting for a captcha")
+	}
+	if App.Canvas.GetPixelColorIndex(1, 2) == 3 {
+		t.Fatal("pixel was placed before solving the captcha")
+	}
+	takeMessages(conn)
+
+	return conn, pixelMsg
+}
+
+// getCaptchaStatus returns the result of the first "captcha_status" message in msgs.
+func getCaptchaStatus(t *testing.T, msgs []interface{}) bool {
+	for _, msg := range msgs {
+		if status, ok := msg.(wsCaptchaStatus); ok {
+			return status.Success
+		}
+	}
+	t.Fatal("no captcha_status message was sent")
+	return false
+}
+
+func TestHandleCaptchaSuccess(t *testing.T) {
+	conn, pixelMsg := setupCaptchaTest(t)
+
+	handleCaptcha(conn, wsCaptchaReq{withType(wsCaptchaType), "test-token"})
+
+	if !getCaptchaStatus(t, takeMessages(conn)) {
+		t.Error("captcha with the right token failed")
+	}
+	if c := App.Canvas.GetPixelColorIndex(pixelMsg.PosX, pixelMsg.PosY); c != pixelMsg.ColorIdx {
+		t.Errorf("pixel has color %d after solving the captcha, expected %d", c, pixelMsg.ColorIdx)
+	}
+	if stack := conn.user.PixelStacker.Stack(); stack != 0 {
+		t.Errorf("user has %d pixels after placing, expected 0", stack)
+	}
+}
+
+func TestHandleCaptchaFailure(t *testing.T) {
+	conn, pixelMsg := setupCaptchaTest(t)
+
+	handleCaptcha(conn, wsCaptchaReq{withType(wsCaptchaType), "wrong-token"})
+
+	if getCaptchaStatus(t, takeMessages(conn)) {
+		t.Error("captcha with the wrong token succeeded")
+	}
+	if c := App.Canvas.GetPixelColorIndex(pixelMsg.PosX, pixelMsg.PosY); c == pixelMsg.ColorIdx {
+		t.Error("pixel was placed after failing the captcha")
+	}
+	if conn.pendingPixel != nil {
+		t.Error("pixel is still waiting after failing the captcha")
+	}
+	if stack := conn.user.PixelStacker.Stack(); stack != 1 {
+		t.Errorf("user has %d pixels after failing the captcha, expected 1", stack)
+	}
+
+	// Note(netux): the pixel is gone, so solving the captcha now doesn't place it
+	handleCaptcha(conn, wsCaptchaReq{withType(wsCaptchaType), "test-token"})
+	if c := App.Canvas.GetPixelColorIndex(pixelMsg.PosX, pixelMsg.PosY); c == pixelMsg.ColorIdx {
+		t.Error("pixel was placed after solving the captcha late")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-akka/configuration"
+)
+
+const (
+	// ChatNonceLength is the length of the nonce that identifies chat messages
+	ChatNonceLength = 32
+)
+
+// ChatFilter replaces disallowed words in chat messages.
+type ChatFilter struct {
+	Enabled  bool
+	Patterns []*regexp.Regexp
+}
+
+// Filter returns the message with every disallowed word replaced with asterisks.
+func (f *ChatFilter) Filter(msg string) string {
+	if !f.Enabled {
+		return msg
+	}
+
+	for _, re := range f.Patterns {
+		msg = re.ReplaceAllStringFunc(msg, censor)
+	}
+	return msg
+}
+
+func censor(s string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(s))
+}
+
+// makeChatFilterFromConf reads the chat.filter block of the config file
+// and creates a ChatFilter.
+func makeChatFilterFromConf(conf *configuration.Config) (*ChatFilter, error) {
+	f := &ChatFilter{
+		Enabled: conf.GetBoolean("chat.filter.enabled"),
+	}
+
+	for _, word := range conf.GetStringList("chat.filter.static") {
+		if word == "" {
+			continue
+		}
+		f.Patterns = append(f.Patterns, regexp.MustCompile("(?i)"+regexp.QuoteMeta(word)))
+	}
+	for _, expr := range conf.GetStringList("chat.filter.regex") {
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chat filter regex \"%s\": %v", expr, err)
+		}
+		f.Patterns = append(f.Patterns, re)
+	}
+
+	return f, nil
+}
+
+const wsChatMessageReqType = "ChatMessage"
+const wsChatMessageResType = "chat_message"
+
+type wsChatMessageReq struct {
+	wsMessage
+	Message string `json:"message"`
+}
+
+type wsChatBadge struct {
+	DisplayName string `json:"displayName"`
+	Tooltip     string `json:"tooltip"`
+	Type        string `json:"type"`
+	CSSIcon     string `json:"cssIcon,omitempty"`
+}
+
+type wsChatMessage struct {
+	Nonce      string        `json:"nonce"`
+	Author     string        `json:"author"`
+	Date       int64         `json:"date"`
+	Badges     []wsChatBadge `json:"badges"`
+	MessageRaw string        `json:"message_raw"`
+}
+
+type wsChatMessageRes struct {
+	wsMessage
+	Message wsChatMessage `json:"message"`
+}
+
+func makeWsChatMessage(msg *DBChatMessage) wsChatMessage {
+	res := wsChatMessage{
+		Nonce:      msg.Nonce,
+		Badges:     []wsChatBadge{},
+		MessageRaw: msg.Filtered,
+	}
+	if msg.Author != nil {
+		res.Author = msg.Author.Name
+	}
+	if msg.Time != nil {
+		res.Date = msg.Time.Unix()
+	}
+	return res
+}
+
+const wsChatHistoryReqType = "ChatHistory"
+const wsChatHistoryResType = "chat_history"
+
+type wsChatHistoryRes struct {
+	wsMessage
+	Messages []wsChatMessage `json:"messages"`
+}
+
+const wsChatbanStateReqType = "ChatbanState"
+const wsChatbanStateResType = "chat_ban_state"
+
+type wsChatbanState struct {
+	wsMessage
+	IsPermanent bool   `json:"permanent"`
+	Reason      string `json:"reason"`
+	Expiry      int64  `json:"expiry"`
+}
+
+const wsMessageCooldownType = "message_cooldown"
+
+type wsMessageCooldown struct {
+	wsMessage
+	Diff    float32 `json:"diff"`
+	Message string  `json:"message"`
+}
+
+// getChatHistoryLength returns how many messages are sent on "ChatHistory".
+func getChatHistoryLength() uint {
+	return uint(App.Conf.GetInt32("chat.historyLength", 100))
+}
+
+func handleChatMessage(conn *wsConn, rawMsg []byte) {
+	if conn.user == nil {
+		return
+	}
+
+	var req wsChatMessageReq
+	if err := json.Unmarshal(rawMsg, &req); err != nil {
+		fmt.Fprintf(os.Stderr, "websocket JSON ChatMessage parsing error: %v\n", err)
+		return
+	}
+
+	var state = App.Users.Snapshot(conn.user.DBUser)
+	if state.IsBanned() {
+		return
+	}
+
+	if state.IsChatBanned() {
+		sendChatbanState(conn)
+		return
+	}
+
+	msg := req.Message
+	if App.Conf.GetBoolean("chat.trimInput") {
+		msg = strings.TrimSpace(msg)
+	}
+	if msg == "" {
+		return
+	}
+	if limit := App.Conf.GetInt32("chat.characterLimit", 256); utf8.RuneCountInString(msg) > int(limit) {
+		msg = string([]rune(msg)[:limit])
+	}
+
+	if key := fmt.Sprint(conn.user.ID); !App.Limits.Allow("chat", key) {
+		conn.queue(wsMessageCooldown{
+			withType(wsMessageCooldownType),
+			float32(App.Limits.RetryAfter("chat", key)) / float32(time.Second),
+			req.Message,
+		})
+		return
+	}
+
+	nonce, err := GenerateToken(ChatNonceLength)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot generate chat message nonce: %v\n", err)
+		return
+	}
+
+	dbMsg, err := App.DB.CreateChatMessage(nonce, conn.user, msg, App.ChatFilter.Filter(msg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot save chat message by user with ID %d in database: %v\n", conn.user.ID, err)
+		return
+	}
+
+	res := wsChatMessageRes{
+		withType(wsChatMessageResType),
+		makeWsChatMessage(dbMsg),
+	}
+	Connections.Broadcast(res)
+}
+
+func handleChatHistory(conn *wsConn) {
+	msgs, err := App.DB.GetChatHistory(getChatHistoryLength())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot get chat history from database: %v\n", err)
+		return
+	}
+
+	res := wsChatHistoryRes{
+		withType(wsChatHistoryResType),
+		make([]wsChatMessage, len(msgs)),
+	}
+	for i, msg := range msgs {
+		res.Messages[i] = makeWsChatMessage(msg)
+	}
+	conn.queue(res)
+}
+
+// sendChatbanState sends a "chat_ban_state" message through the websocket connection conn
+func sendChatbanState(conn *wsConn) {
+	if conn.user == nil {
+		return
+	}
+
+	var state = App.Users.Snapshot(conn.user.DBUser)
+	res := wsChatbanState{
+		wsMessage:   withType(wsChatbanStateResType),
+		IsPermanent: state.IsPermanentlyChatBanned,
+		Reason:      state.ChatBanReason,
+	}
+	res.Expiry = toMillis(state.ChatBanExpiry)
+	conn.queue(res)
+}
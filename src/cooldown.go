@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/go-akka/configuration"
+)
+
+// CooldownConfig holds the settings the cooldown is computed with.
+type CooldownConfig struct {
+	// Cooldown is used as is if UseStatic is set or activity cooldown is disabled.
+	Cooldown  time.Duration
+	UseStatic bool
+
+	ActivityEnabled    bool
+	ActivityMultiplier float64
+
+	BackgroundEnabled    bool
+	BackgroundMultiplier float64
+
+	// SelfPixelIncrease is whenever placing over your own pixels also uses BackgroundMultiplier.
+	SelfPixelIncrease bool
+}
+
+// Placement describes the pixel a cooldown is computed for.
+// The zero value describes a placement over a background pixel.
+type Placement struct {
+	// OverPlaced is whenever the placement is over a pixel placed by a player.
+	OverPlaced bool
+	// OverOwn is whenever the placement is over a pixel placed by the same user.
+	OverOwn bool
+}
+
+// makeCooldownConfigFromConf reads the cooldown settings from the config file.
+func makeCooldownConfigFromConf(conf *configuration.Config) CooldownConfig {
+	return CooldownConfig{
+		Cooldown:  conf.GetTimeDurationInfiniteNotAllowed("cooldown", 3*time.Minute),
+		UseStatic: conf.GetBoolean("useStaticCooldown"),
+
+		ActivityEnabled:    conf.GetBoolean("activityCooldown.enabled"),
+		ActivityMultiplier: conf.GetFloat64("activityCooldown.multiplier", 1),
+
+		BackgroundEnabled:    conf.GetBoolean("backgroundPixel.enabled"),
+		BackgroundMultiplier: conf.GetFloat64("backgroundPixel.multiplier", 1),
+
+		SelfPixelIncrease: conf.GetBoolean("selfPixelTimeIncrease"),
+	}
+}
+
+// Compute returns the cooldown after a placement with the given amount of logged in users online.
+// With activity cooldown, it grows with the square root of the online users,
+// as in Pxls: (2.5 * sqrt(online + 11.96) + 6.5) * multiplier seconds.
+func (c CooldownConfig) Compute(online int, p Placement) time.Duration {
+	var secs float64
+	if c.UseStatic || !c.ActivityEnabled {
+		secs = c.Cooldown.Seconds()
+	} else {
+		secs = (2.5*math.Sqrt(float64(online)+11.96) + 6.5) * c.ActivityMultiplier
+	}
+
+	if c.BackgroundEnabled && p.OverPlaced && (!p.OverOwn || c.SelfPixelIncrease) {
+		secs *= c.BackgroundMultiplier
+	}
+
+	return time.Duration(secs * float64(time.Second))
+}
+
+// getPlacement describes the placement of the user over the given pixel,
+// which is nil if the position was never placed on.
+func getPlacement(u *User, pixel *DBPixel) Placement {
+	if pixel == nil || pixel.PlacerID == 0 {
+		return Placement{}
+	}
+	// Note(netux): rollbacks to the background and cleared areas are saved as mod actions
+	// with the default color, which leaves the position as if it was never placed on
+	if pixel.IsModAction && pixel.ColorIdx == byte(App.Conf.GetInt32("board.defaultColor")) {
+		return Placement{}
+	}
+	// Note(netux): the placer of mod actions, rollbacks included, is the moderator,
+	// so they are never the user's own pixels
+	return Placement{
+		OverPlaced: true,
+		OverOwn:    !pixel.IsModAction && pixel.PlacerID == u.ID,
+	}
+}
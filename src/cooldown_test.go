@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCooldownCompute(t *testing.T) {
+	static := CooldownConfig{
+		Cooldown:             3 * time.Minute,
+		BackgroundEnabled:    true,
+		BackgroundMultiplier: 1.6,
+	}
+	activity := CooldownConfig{
+		Cooldown:           3 * time.Minute,
+		ActivityEnabled:    true,
+		ActivityMultiplier: 1,
+	}
+
+	withSelfIncrease := static
+	withSelfIncrease.SelfPixelIncrease = true
+	withoutBackground := static
+	withoutBackground.BackgroundEnabled = false
+	forcedStatic := activity
+	forcedStatic.UseStatic = true
+	doubledActivity := activity
+	doubledActivity.ActivityMultiplier = 2
+
+	var (
+		background = Placement{}
+		placed     = Placement{OverPlaced: true}
+		own        = Placement{OverPlaced: true, OverOwn: true}
+	)
+
+	tests := []struct {
+		name     string
+		conf     CooldownConfig
+		online   int
+		p        Placement
+		expected time.Duration
+	}{
+		{"static over background", static, 100, background, 180 * time.Second},
+		{"static over placed", static, 100, placed, 288 * time.Second},
+		{"static over own", static, 100, own, 180 * time.Second},
+		{"static over own with self increase", withSelfIncrease, 100, own, 288 * time.Second},
+		{"static over placed without background", withoutBackground, 100, placed, 180 * time.Second},
+		{"activity with no one online", activity, 0, background, 15146 * time.Millisecond},
+		{"activity with 100 online", activity, 100, background, 32953 * time.Millisecond},
+		{"activity with multiplier", doubledActivity, 100, background, 65906 * time.Millisecond},
+		{"activity with static forced", forcedStatic, 100, background, 180 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.conf.Compute(test.online, test.p)
+			if diff := got - test.expected; diff < -time.Millisecond || diff > time.Millisecond {
+				t.Errorf("Compute(%d, %+v) = %v, expected %v", test.online, test.p, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestGetPlacement(t *testing.T) {
+	setupTestApp(t, "")
+	u := MakeUser(&DBUser{ID: 1})
+	const modID = 2
+
+	tests := []struct {
+		name     string
+		pixel    *DBPixel
+		expected Placement
+	}{
+		{"never placed on", nil, Placement{}},
+		{"without placer", &DBPixel{ColorIdx: 1}, Placement{}},
+		{"placed by someone else", &DBPixel{PlacerID: 3, ColorIdx: 1}, Placement{OverPlaced: true}},
+		{"placed by the user", &DBPixel{PlacerID: 1, ColorIdx: 1}, Placement{OverPlaced: true, OverOwn: true}},
+		{"placed by the user with the default color", &DBPixel{PlacerID: 1, ColorIdx: 0}, Placement{OverPlaced: true, OverOwn: true}},
+		{"filled by a moderator", &DBPixel{PlacerID: modID, ColorIdx: 1, IsModAction: true}, Placement{OverPlaced: true}},
+		{"cleared by a moderator", &DBPixel{PlacerID: modID, ColorIdx: 0, IsModAction: true}, Placement{}},
+		{"rolled back to the background", &DBPixel{PlacerID: modID, ColorIdx: 0, IsModAction: true, RollbackAction: true}, Placement{}},
+		{"rolled back to someone else's pixel", &DBPixel{PlacerID: modID, ColorIdx: 1, IsModAction: true, RollbackAction: true}, Placement{OverPlaced: true}},
+		{"mod action by the user", &DBPixel{PlacerID: 1, ColorIdx: 1, IsModAction: true, RollbackAction: true}, Placement{OverPlaced: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getPlacement(u, test.pixel); got != test.expected {
+				t.Errorf("getPlacement() = %+v, expected %+v", got, test.expected)
+			}
+		})
+	}
+}
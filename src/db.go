@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -29,6 +30,13 @@ func (db *Database) GetUserByLogin(login UserLogin) (u *DBUser, err error) {
 	return
 }
 
+// GetUserByName returns the user with the given username.
+func (db *Database) GetUserByName(name string) (u *DBUser, err error) {
+	u = new(DBUser)
+	err = db.sql.First(u, "username = ?", name).Error
+	return
+}
+
 // GetUserByToken returns the user with the given session token.
 func (db *Database) GetUserByToken(token string) (u *DBUser, err error) {
 	s := new(DBSession)
@@ -40,6 +48,13 @@ func (db *Database) GetUserByToken(token string) (u *DBUser, err error) {
 	return
 }
 
+// IsUsernameTaken returns whenever an user with the given name exists.
+func (db *Database) IsUsernameTaken(name string) (bool, error) {
+	var c uint
+	err := db.sql.Model(&DBUser{}).Where("username = ?", name).Count(&c).Error
+	return c > 0, err
+}
+
 // CreateUser creates an user with the given name, login, ip and user agent.
 func (db *Database) CreateUser(name string, login UserLogin, ip, ua string) (*DBUser, error) {
 	user := &DBUser{
@@ -55,7 +70,9 @@ func (db *Database) CreateUser(name string, login UserLogin, ip, ua string) (*DB
 	if c > 0 {
 		return nil, fmt.Errorf("user with same login (%s) already in database", user.Login.String())
 	}
-	db.sql.Create(user)
+	if err := db.sql.Create(user).Error; err != nil {
+		return nil, err
+	}
 
 	return user, nil
 }
@@ -68,8 +85,18 @@ func (db *Database) SaveSessionForUser(uid uint, token string) error {
 	}).Error
 }
 
-// PlacePixel inserts a pixel into the database.
-func (db *Database) PlacePixel(x, y uint, color byte, placer *User) error {
+// DeleteSession deletes the session with the given token.
+func (db *Database) DeleteSession(token string) error {
+	return db.sql.Delete(DBSession{}, "token = ?", token).Error
+}
+
+// DeleteSessionsForUser deletes every session of the user with the given ID.
+func (db *Database) DeleteSessionsForUser(uid uint) error {
+	return db.sql.Delete(DBSession{}, "who = ?", uid).Error
+}
+
+// PlacePixel inserts a pixel into the database and returns it.
+func (db *Database) PlacePixel(x, y uint, color byte, placer *User) (*DBPixel, error) {
 	tx := db.sql.Begin()
 	defer func() {
 		if r := recover(); r != nil {
@@ -77,41 +104,601 @@ func (db *Database) PlacePixel(x, y uint, color byte, placer *User) error {
 		}
 	}()
 
+	/// Save pixel
+	pixel := &DBPixel{
+		PosX:         x,
+		PosY:         y,
+		ColorIdx:     color,
+		IsMostRecent: true,
+	}
+
 	/// Unset IsMostRecent on last pixel
-	if oldPixel := new(DBPixel); !tx.First(oldPixel, "x = ? AND y = ? AND most_recent", x, y).RecordNotFound() {
+	oldPixel := new(DBPixel)
+	if err := tx.First(oldPixel, "x = ? AND y = ? AND most_recent", x, y).Error; err == nil {
 		oldPixel.IsMostRecent = false
-		tx.Save(oldPixel)
+		if err := tx.Save(oldPixel).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		pixel.SecondaryID = &oldPixel.ID
+	} else if !gorm.IsRecordNotFoundError(err) {
+		tx.Rollback()
+		return nil, err
 	}
 
-	/// Save pixel
-	pixel := &DBPixel{
-		PosX:     x,
-		PosY:     y,
-		ColorIdx: color,
-	}
 	if placer != nil {
 		pixel.PlacerID = placer.ID
 	}
-	tx.Save(pixel)
+	if err := tx.Save(pixel).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 
 	/// Update user pixel counts
-	placer.DBUser.PixelCount++
-	placer.DBUser.PixelCountAlltime++
-	tx.Save(placer.DBUser)
+	// Note(netux): only the counts are updated, saving the whole user would overwrite
+	// columns changed since it was fetched (e.g. stacked pixels) with stale values
+	if placer != nil {
+		err := tx.Model(&DBUser{}).Where("id = ?", placer.ID).UpdateColumns(map[string]interface{}{
+			"pixel_count":         gorm.Expr("pixel_count + 1"),
+			"pixel_count_alltime": gorm.Expr("pixel_count_alltime + 1"),
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	// Note(netux): the cached user is only updated once the counts are in the database
+	if placer != nil {
+		App.Users.Update(placer.DBUser, func(u *DBUser) {
+			u.PixelCount++
+			u.PixelCountAlltime++
+		})
+	}
+	return pixel, nil
+}
+
+// GetPixelAt returns the most recent pixel at the given position.
+func (db *Database) GetPixelAt(x, y uint) (p *DBPixel, err error) {
+	p = new(DBPixel)
+	err = db.sql.First(p, "x = ? AND y = ? AND most_recent", x, y).Error
+	return
+}
+
+// UndoPixel reverts a pixel to the one placed before it, marking it as undone,
+// and returns the pixel that is now the most recent at that position,
+// or nil if the position went back to being a background pixel.
+func (db *Database) UndoPixel(pixel *DBPixel, placer *User) (*DBPixel, error) {
+	tx := db.sql.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	/// Check the pixel wasn't placed over or undone already
+	if err := tx.First(pixel, "id = ?", pixel.ID).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if !pixel.IsMostRecent || pixel.Undone {
+		tx.Rollback()
+		return nil, &NotFoundError{fmt.Sprintf("pixel with ID %d is not undoable", pixel.ID)}
+	}
+
+	pixel.IsMostRecent = false
+	pixel.Undone = true
+	if err := tx.Save(pixel).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	/// Restore previous pixel
+	var prevPixel *DBPixel
+	if pixel.SecondaryID != nil {
+		prevPixel = new(DBPixel)
+		if err := tx.First(prevPixel, "id = ?", *pixel.SecondaryID).Error; err == nil {
+			prevPixel.IsMostRecent = true
+			if err := tx.Save(prevPixel).Error; err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		} else if gorm.IsRecordNotFoundError(err) {
+			prevPixel = nil
+		} else {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	/// Save undo action
+	undoPixel := &DBPixel{
+		PosX:        pixel.PosX,
+		PosY:        pixel.PosY,
+		PlacerID:    placer.ID,
+		SecondaryID: &pixel.ID,
+		UndoAction:  true,
+	}
+	if prevPixel != nil {
+		undoPixel.ColorIdx = prevPixel.ColorIdx
+	} else {
+		undoPixel.ColorIdx = byte(App.Conf.GetInt32("board.defaultColor"))
+	}
+	if err := tx.Save(undoPixel).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	// Note(netux): most_recent defaults to true on insert
+	if err := tx.Model(undoPixel).Update("most_recent", false).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	/// Update user pixel counts
+	err := tx.Model(&DBUser{}).Where("id = ?", placer.ID).UpdateColumns(map[string]interface{}{
+		"pixel_count":         gorm.Expr("CASE WHEN pixel_count > 0 THEN pixel_count - 1 ELSE 0 END"),
+		"pixel_count_alltime": gorm.Expr("CASE WHEN pixel_count_alltime > 0 THEN pixel_count_alltime - 1 ELSE 0 END"),
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	// Note(netux): the cached user is only updated once the counts are in the database
+	App.Users.Update(placer.DBUser, func(u *DBUser) {
+		if u.PixelCount > 0 {
+			u.PixelCount--
+		}
+		if u.PixelCountAlltime > 0 {
+			u.PixelCountAlltime--
+		}
+	})
+	return prevPixel, nil
+}
+
+// RollbackUser reverts every position the user with the given ID is the most recent placer on,
+// for pixels placed after since, to the last pixel placed there by someone else.
+// The reverted pixels are saved as rollback actions by the given moderator and returned.
+func (db *Database) RollbackUser(mod *User, uid uint, since time.Time) ([]*DBPixel, error) {
+	tx := db.sql.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var placed []*DBPixel
+	err := tx.Where("who = ? AND most_recent AND time > ?", uid, since).Order("id").Find(&placed).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	rollbacks := make([]*DBPixel, 0, len(placed))
+	for _, pixel := range placed {
+		rollback := &DBPixel{
+			PosX:           pixel.PosX,
+			PosY:           pixel.PosY,
+			PlacerID:       mod.ID,
+			ColorIdx:       byte(App.Conf.GetInt32("board.defaultColor")),
+			SecondaryID:    &pixel.ID,
+			IsModAction:    true,
+			RollbackAction: true,
+			IsMostRecent:   true,
+		}
+
+		/// Find the last pixel placed by someone else
+		prevID := pixel.SecondaryID
+		for prevID != nil {
+			prevPixel := new(DBPixel)
+			if err := tx.First(prevPixel, "id = ?", *prevID).Error; err != nil {
+				if !gorm.IsRecordNotFoundError(err) {
+					tx.Rollback()
+					return nil, err
+				}
+				break
+			}
+			if prevPixel.PlacerID != uid {
+				rollback.ColorIdx = prevPixel.ColorIdx
+				break
+			}
+			prevID = prevPixel.SecondaryID
+		}
+
+		tx.Model(pixel).Update("most_recent", false)
+		tx.Save(rollback)
+		rollbacks = append(rollbacks, rollback)
+	}
+
+	return rollbacks, tx.Commit().Error
+}
+
+// UndoRollbackUser reverts the rollbacks of pixels placed after since by the user with the
+// given ID which are still the most recent at their position, and returns the restored pixels.
+// Each reverted rollback is saved as an undo action by the given moderator.
+func (db *Database) UndoRollbackUser(mod *User, uid uint, since time.Time) ([]*DBPixel, error) {
+	tx := db.sql.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var rollbacks []*DBPixel
+	err := tx.
+		Select("pixels.*").
+		Joins("JOIN pixels AS rolled ON rolled.id = pixels.secondary_id").
+		Where("pixels.rollback_action AND pixels.most_recent AND rolled.who = ? AND rolled.time > ?", uid, since).
+		Order("pixels.id").
+		Find(&rollbacks).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	restored := make([]*DBPixel, 0, len(rollbacks))
+	for _, rollback := range rollbacks {
+		pixel := new(DBPixel)
+		if err := tx.First(pixel, "id = ?", *rollback.SecondaryID).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		tx.Model(rollback).Updates(map[string]interface{}{"most_recent": false, "undone": true})
+		tx.Model(pixel).Update("most_recent", true)
+
+		/// Save undo action
+		undoPixel := &DBPixel{
+			PosX:           pixel.PosX,
+			PosY:           pixel.PosY,
+			PlacerID:       mod.ID,
+			ColorIdx:       pixel.ColorIdx,
+			SecondaryID:    &rollback.ID,
+			UndoAction:     true,
+			IsModAction:    true,
+			RollbackAction: true,
+		}
+		tx.Save(undoPixel)
+		// Note(netux): most_recent defaults to true on insert
+		tx.Model(undoPixel).Update("most_recent", false)
+
+		restored = append(restored, pixel)
+	}
+
+	return restored, tx.Commit().Error
+}
+
+// PlaceModPixels inserts the pixels into the database as mod actions by the given moderator.
+// Only the position and color of the pixels have to be set, their IDs aren't set after inserting.
+func (db *Database) PlaceModPixels(pixels []*DBPixel, mod *User) error {
+	tx := db.sql.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if len(pixels) == 0 {
+		return tx.Commit().Error
+	}
+
+	/// Find the last pixels in a single query, over the rectangle containing every pixel
+	minX, minY, maxX, maxY := pixels[0].PosX, pixels[0].PosY, pixels[0].PosX, pixels[0].PosY
+	for _, pixel := range pixels {
+		if pixel.PosX < minX {
+			minX = pixel.PosX
+		}
+		if pixel.PosX > maxX {
+			maxX = pixel.PosX
+		}
+		if pixel.PosY < minY {
+			minY = pixel.PosY
+		}
+		if pixel.PosY > maxY {
+			maxY = pixel.PosY
+		}
+	}
+
+	var oldPixels []*DBPixel
+	err := tx.Select("id, x, y").
+		Where("most_recent AND x >= ? AND x <= ? AND y >= ? AND y <= ?", minX, maxX, minY, maxY).
+		Find(&oldPixels).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	oldIDs := make(map[[2]uint]uint, len(oldPixels))
+	for _, oldPixel := range oldPixels {
+		oldIDs[[2]uint{oldPixel.PosX, oldPixel.PosY}] = oldPixel.ID
+	}
+
+	var replacedIDs []uint
+	for _, pixel := range pixels {
+		pixel.PlacerID = mod.ID
+		pixel.IsModAction = true
+		pixel.IsMostRecent = true
+
+		if id, ok := oldIDs[[2]uint{pixel.PosX, pixel.PosY}]; ok {
+			pixel.SecondaryID = &id
+			replacedIDs = append(replacedIDs, id)
+		}
+	}
+
+	/// Unset IsMostRecent on last pixels
+	// Note(netux): in chunks, databases limit the amount of placeholders in a query
+	for i := 0; i < len(replacedIDs); i += modPixelsChunkSize {
+		end := i + modPixelsChunkSize
+		if end > len(replacedIDs) {
+			end = len(replacedIDs)
+		}
+		err := tx.Model(&DBPixel{}).Where("id IN (?)", replacedIDs[i:end]).Update("most_recent", false).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	/// Insert the pixels, many per query
+	var rowsPerInsert = modPixelsChunkSize / modPixelsInsertColumns
+	for i := 0; i < len(pixels); i += rowsPerInsert {
+		end := i + rowsPerInsert
+		if end > len(pixels) {
+			end = len(pixels)
+		}
+
+		var (
+			rows = make([]string, 0, end-i)
+			args = make([]interface{}, 0, (end-i)*modPixelsInsertColumns)
+		)
+		for _, pixel := range pixels[i:end] {
+			rows = append(rows, "(?, ?, ?, ?, ?, ?, ?)")
+			args = append(args, pixel.PosX, pixel.PosY, pixel.PlacerID, pixel.ColorIdx, pixel.SecondaryID, pixel.IsModAction, pixel.IsMostRecent)
+		}
+		err := tx.Exec("INSERT INTO pixels (x, y, who, color, secondary_id, mod_action, most_recent) VALUES "+strings.Join(rows, ", "), args...).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 
 	return tx.Commit().Error
 }
 
+// modPixelsChunkSize is how many values PlaceModPixels sends per query.
+// Note(netux): SQLite allows only 999 by default.
+const modPixelsChunkSize = 900
+
+// modPixelsInsertColumns is how many values PlaceModPixels inserts per pixel.
+const modPixelsInsertColumns = 7
+
+// EachLastPlayerPixelIn calls fn with the last pixel placed by a player, and not undone
+// nor rolled back, at every position inside the rectangle which has one.
+func (db *Database) EachLastPlayerPixelIn(x, y, w, h uint, fn func(p *DBPixel)) error {
+	rows, err := db.sql.Model(&DBPixel{}).
+		Select("x, y, color").
+		Where("x >= ? AND x < ? AND y >= ? AND y < ?", x, x+w, y, y+h).
+		Where("NOT mod_action AND NOT undone AND NOT undo_action").
+		// Note(netux): a rollback references the last pixel of the user at the position,
+		// and reverts it along with the ones the user placed there before it
+		Where(`NOT EXISTS (
+			SELECT 1 FROM pixels AS rb JOIN pixels AS rolled ON rolled.id = rb.secondary_id
+			WHERE rb.rollback_action AND NOT rb.undo_action AND NOT rb.undone
+			AND rolled.x = pixels.x AND rolled.y = pixels.y AND rolled.who = pixels.who AND rolled.id >= pixels.id
+		)`).
+		Order("id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Note(netux): pixels are ordered, so later ones overwrite earlier ones at the same position
+	last := make(map[[2]uint]*DBPixel)
+	for rows.Next() {
+		p := new(DBPixel)
+		if err := rows.Scan(&p.PosX, &p.PosY, &p.ColorIdx); err != nil {
+			return err
+		}
+		last[[2]uint{p.PosX, p.PosY}] = p
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range last {
+		fn(p)
+	}
+	return nil
+}
+
+// EachPixelSince calls fn with the position and time of every pixel placed
+// after t, in the order they were placed. Undone pixels are skipped.
+func (db *Database) EachPixelSince(t time.Time, fn func(p *DBPixel)) error {
+	rows, err := db.sql.Model(&DBPixel{}).
+		Select("x, y, time").
+		Where("time > ? AND NOT undone AND NOT undo_action", t).
+		Order("id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := new(DBPixel)
+		if err := rows.Scan(&p.PosX, &p.PosY, &p.Time); err != nil {
+			return err
+		}
+		fn(p)
+	}
+
+	return rows.Err()
+}
+
+// EachPlacedPosition calls fn with every position that was ever placed on.
+func (db *Database) EachPlacedPosition(fn func(x, y uint)) error {
+	rows, err := db.sql.Model(&DBPixel{}).Select("DISTINCT x, y").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var x, y uint
+		if err := rows.Scan(&x, &y); err != nil {
+			return err
+		}
+		fn(x, y)
+	}
+
+	return rows.Err()
+}
+
+// GetLookupAt returns the most recent pixel at the given position
+// along with information about the user who placed it.
+func (db *Database) GetLookupAt(x, y uint) (l *DBLookup, err error) {
+	l = new(DBLookup)
+	err = db.sql.Table("pixels").
+		Select("pixels.id, pixels.x, pixels.y, pixels.color, pixels.time, users.username, users.pixel_count, users.pixel_count_alltime, users.login, users.user_agent").
+		Joins("LEFT JOIN users ON users.id = pixels.who").
+		Where("pixels.x = ? AND pixels.y = ? AND pixels.most_recent", x, y).
+		Scan(l).Error
+	return
+}
+
+// CreateChatMessage inserts a chat message sent by the given user into the database.
+func (db *Database) CreateChatMessage(nonce string, author *User, content, filtered string) (*DBChatMessage, error) {
+	now := time.Now()
+	msg := &DBChatMessage{
+		Nonce:    nonce,
+		AuthorID: author.ID,
+		Time:     &now,
+		Content:  content,
+		Filtered: filtered,
+	}
+	err := db.sql.Create(msg).Error
+
+	// Note(netux): set after creating so gorm doesn't save the author too
+	msg.Author = author.DBUser
+	return msg, err
+}
+
+// GetChatHistory returns the last n chat messages, newest first.
+func (db *Database) GetChatHistory(n uint) (msgs []*DBChatMessage, err error) {
+	err = db.sql.Preload("Author").Order("sent DESC").Limit(n).Find(&msgs).Error
+	return
+}
+
+// GetPixelByID returns the pixel with the given ID.
+func (db *Database) GetPixelByID(id uint) (p *DBPixel, err error) {
+	p = new(DBPixel)
+	err = db.sql.First(p, "id = ?", id).Error
+	return
+}
+
+// GetChatMessageByNonce returns the chat message with the given nonce.
+func (db *Database) GetChatMessageByNonce(nonce string) (msg *DBChatMessage, err error) {
+	msg = new(DBChatMessage)
+	err = db.sql.First(msg, "nonce = ?", nonce).Error
+	return
+}
+
+// CreateReport inserts a report into the database.
+func (db *Database) CreateReport(report *DBReport) error {
+	return db.sql.Create(report).Error
+}
+
+// GetOpenReports returns every report that wasn't resolved yet, oldest first.
+func (db *Database) GetOpenReports() (reports []*DBReport, err error) {
+	err = db.sql.Where("NOT closed").Order("id").Find(&reports).Error
+	return
+}
+
+// CreateAnnouncement inserts an announcement by the given user, active until expiry, into the database.
+func (db *Database) CreateAnnouncement(author *User, message string, expiry time.Time) (*DBAnnouncement, error) {
+	now := time.Now()
+	a := &DBAnnouncement{
+		AuthorID: author.ID,
+		Message:  message,
+		Time:     &now,
+		Expiry:   &expiry,
+	}
+	err := db.sql.Create(a).Error
+	return a, err
+}
+
+// GetActiveAnnouncements returns every announcement that didn't expire yet, oldest first.
+func (db *Database) GetActiveAnnouncements() (announcements []*DBAnnouncement, err error) {
+	err = db.sql.Where("expiry > ?", time.Now()).Order("id").Find(&announcements).Error
+	return
+}
+
+// ClaimReport sets the moderator with the given ID as the one handling the open report.
+func (db *Database) ClaimReport(id, claimerID uint) error {
+	res := db.sql.Model(&DBReport{}).Where("id = ? AND NOT closed", id).Update("claimed_by", claimerID)
+	if res.Error == nil && res.RowsAffected == 0 {
+		return &NotFoundError{fmt.Sprintf("no open report with ID %d", id)}
+	}
+	return res.Error
+}
+
+// ResolveReport closes the open report with the given ID.
+func (db *Database) ResolveReport(id, resolverID uint) error {
+	res := db.sql.Model(&DBReport{}).Where("id = ? AND NOT closed", id).Updates(map[string]interface{}{
+		"closed":     true,
+		"claimed_by": resolverID,
+	})
+	if res.Error == nil && res.RowsAffected == 0 {
+		return &NotFoundError{fmt.Sprintf("no open report with ID %d", id)}
+	}
+	return res.Error
+}
+
+// SetUserBan sets the ban expiry timestamp, reason, role and role before being banned
+// of the user with the given ID. A nil expiry means the user is not temporarily banned.
+func (db *Database) SetUserBan(uid uint, expiry *time.Time, reason string, role, previousRole UserRole) error {
+	return db.sql.Model(&DBUser{}).Where("id = ?", uid).Updates(map[string]interface{}{
+		"ban_expiry":    expiry,
+		"ban_reason":    reason,
+		"role":          role,
+		"previous_role": previousRole,
+	}).Error
+}
+
+// SetUserRole sets the role of the user with the given ID.
+func (db *Database) SetUserRole(uid uint, role UserRole) error {
+	return db.sql.Model(&DBUser{}).Where("id = ?", uid).Update("role", role).Error
+}
+
+// SetUserPreviousRole sets the role given back to the user with the given ID when unbanned.
+func (db *Database) SetUserPreviousRole(uid uint, role UserRole) error {
+	return db.sql.Model(&DBUser{}).Where("id = ?", uid).Update("previous_role", role).Error
+}
+
 // SetUserCooldownExpiry sets the cooldown expiry timestamp of the user with the given ID.
 func (db *Database) SetUserCooldownExpiry(uid uint, ce time.Time) error {
-	u := &DBUser{CooldownExpiry: &ce}
-	return db.sql.Model(u).Update(u).Where("id = ?", uid).Error
+	return db.sql.Model(&DBUser{}).Where("id = ?", uid).Update("cooldown_expiry", ce).Error
 }
 
 // SetUserStackedPixels sets the stacked pixel count of the user with the given ID.
 func (db *Database) SetUserStackedPixels(uid uint, stack uint) error {
-	u := &DBUser{Stacked: int(stack)}
-	return db.sql.Model(u).Update(u).Where("id = ?", uid).Error
+	return db.sql.Model(&DBUser{}).Where("id = ?", uid).Update("stacked", stack).Error
+}
+
+// SetUserStacker sets both the stacked pixel count and cooldown expiry of the user with the given ID.
+func (db *Database) SetUserStacker(uid uint, stack uint, ce time.Time) error {
+	return db.sql.Model(&DBUser{}).Where("id = ?", uid).Updates(map[string]interface{}{
+		"stacked":         stack,
+		"cooldown_expiry": ce,
+	}).Error
 }
 
 // Close closes the internal connection to the database.
@@ -119,6 +706,17 @@ func (db *Database) Close() error {
 	return db.sql.Close()
 }
 
+// IsDuplicateKeyError returns whenever an error was caused by
+// inserting a row which has the same value as another in an unique column.
+func IsDuplicateKeyError(err error) bool {
+	// https://dev.mysql.com/doc/refman/8.0/en/server-error-reference.html#error_er_dup_entry
+	e, ok := err.(*mysql.MySQLError)
+	return ok && e.Number == 1062
+}
+
+// dbModels are the models which have a table in the database.
+var dbModels = []interface{}{&DBPixel{}, &DBUser{}, &DBSession{}, &DBChatMessage{}, &DBReport{}, &DBAnnouncement{}}
+
 // MakeDatabase creates and connects to the database.
 func MakeDatabase(driver, user, pass, uri string) (*Database, error) {
 	// https://github.com/pxlsspace/Pxls/blob/master/src/main/java/space/pxls/data/Database.java#L49
@@ -142,8 +740,16 @@ func MakeDatabase(driver, user, pass, uri string) (*Database, error) {
 		return nil, err
 	}
 
+	// Note(netux): users signed in by IP used to share the same username, which the unique index on it doesn't allow
+	if conn.HasTable(&DBUser{}) {
+		err := conn.Exec("UPDATE users SET username = CONCAT(?, id) WHERE username = ?", IPUsernamePrefix, IPUsernamePrefix).Error
+		if err != nil {
+			return nil, fmt.Errorf("cannot rename users signed in by IP: %v", err)
+		}
+	}
+
 	// Generate tables and migrate them when a difference with the models is detected.
-	conn.AutoMigrate(&DBPixel{}, &DBUser{}, &DBSession{})
+	conn.AutoMigrate(dbModels...)
 
 	return &Database{
 		sql:    conn,
@@ -160,15 +766,15 @@ type DBPixel struct {
 	ColorIdx byte       `gorm:"column:color; not null"`
 	Time     *time.Time `gorm:"type:timestamp; not null; default:now(6)"`
 
-	// TODO(netux): implement these
 	// Secondary ID is the previous pixel's ID.
 	// If the pixel was rollbacked, this is the ID that was changed from for rollback action,
 	// is NULL if there's no previous or it was undo of rollback
-	// SecondaryID uint
-	// IsModAction bool `gorm:"column:mod_action; not null; default:false"`
-	// RollbackAction bool `gorm:"not null; default:false"`
-	// Undone         byte `gorm:"not null; default:0"`
-	// UndoAction     bool `gorm:"not null; default:false"`
+	SecondaryID *uint `gorm:"column:secondary_id"`
+	Undone      bool  `gorm:"not null; default:false"`
+	UndoAction  bool  `gorm:"not null; default:false"`
+
+	IsModAction    bool `gorm:"column:mod_action; not null; default:false"`
+	RollbackAction bool `gorm:"not null; default:false"`
 
 	IsMostRecent bool `gorm:"column:most_recent; not null; default:true; index:most_recent"`
 }
@@ -178,10 +784,24 @@ func (*DBPixel) TableName() string {
 	return "pixels"
 }
 
+// DBLookup represents a pixel joined with its placer's information.
+type DBLookup struct {
+	ID                uint       `gorm:"column:id"`
+	PosX              uint       `gorm:"column:x"`
+	PosY              uint       `gorm:"column:y"`
+	ColorIdx          byte       `gorm:"column:color"`
+	Time              *time.Time `gorm:"column:time"`
+	Username          string     `gorm:"column:username"`
+	PixelCount        uint64     `gorm:"column:pixel_count"`
+	PixelCountAlltime uint64     `gorm:"column:pixel_count_alltime"`
+	Login             string     `gorm:"column:login"`
+	UserAgent         string     `gorm:"column:user_agent"`
+}
+
 // DBUser represents an user as stored in the database
 type DBUser struct {
 	ID                uint     `gorm:"not null; primary_key; auto_increment"`
-	Name              string   `gorm:"column:username; type:varchar(32); not null"`
+	Name              string   `gorm:"column:username; type:varchar(32); not null; unique_index"`
 	Role              UserRole `gorm:"type:varchar(16); not null; default:'USER'"`
 	PixelCount        uint64   `gorm:"not null; default:0"`
 	PixelCountAlltime uint64   `gorm:"not null; default:0"`
@@ -197,6 +817,9 @@ type DBUser struct {
 	Stacked        int        `gorm:"default:0"`
 	CooldownExpiry *time.Time `gorm:"type:timestamp"`
 
+	// PreviousRole is the role the user had before being (shadow)banned, given back when unbanned.
+	PreviousRole UserRole `gorm:"type:varchar(16); not null; default:''"`
+
 	BanExpiry               *time.Time `gorm:"type:timestamp"`
 	BanReason               string     `gorm:"type:varchar(512); not null; default:''"`
 	ChatBanExpiry           *time.Time `gorm:"type:timestamp; default:now()"`
@@ -217,6 +840,21 @@ func (u *DBUser) AfterFind() error {
 	return nil
 }
 
+// IsBanned returns whenever the user is currently not allowed to place.
+func (u *DBUser) IsBanned() bool {
+	return u.Role == BannedUserRole || (u.BanExpiry != nil && time.Now().Before(*u.BanExpiry))
+}
+
+// IsShadowbanned returns whenever the user's pixels are only shown to themselves.
+func (u *DBUser) IsShadowbanned() bool {
+	return u.Role == ShadowbannedUserRole
+}
+
+// IsChatBanned returns whenever the user is currently not allowed to chat.
+func (u *DBUser) IsChatBanned() bool {
+	return u.IsPermanentlyChatBanned || (u.ChatBanExpiry != nil && time.Now().Before(*u.ChatBanExpiry))
+}
+
 // TableName returns the name of the users table.
 func (*DBUser) TableName() string {
 	return "users"
@@ -241,3 +879,55 @@ func (s *DBSession) BeforeUpdate() error {
 func (*DBSession) TableName() string {
 	return "sessions"
 }
+
+// DBChatMessage represents a chat message as stored in the database.
+type DBChatMessage struct {
+	Nonce    string     `gorm:"type:varchar(36); not null; primary_key"`
+	AuthorID uint       `gorm:"column:author; not null"`
+	Author   *DBUser    `gorm:"foreignkey:AuthorID"`
+	Time     *time.Time `gorm:"column:sent; type:timestamp; not null; default:now(6); index:sent"`
+	Content  string     `gorm:"type:varchar(2048); not null"`
+	Filtered string     `gorm:"type:varchar(2048); not null; default:''"`
+}
+
+// TableName returns the name of the chat messages table.
+func (*DBChatMessage) TableName() string {
+	return "chat_messages"
+}
+
+// DBReport represents a report of a pixel or chat message as stored in the database.
+type DBReport struct {
+	ID         uint       `gorm:"not null; primary_key; auto_increment"`
+	ReporterID uint       `gorm:"column:who; not null"`
+	ReportedID uint       `gorm:"column:reported; not null"`
+	Message    string     `gorm:"type:varchar(2048); not null"`
+	Time       *time.Time `gorm:"type:timestamp; not null; default:now(6)"`
+
+	// Only one of PixelID or ChatMessageNonce is set, depending on what was reported.
+	PixelID          *uint   `gorm:"column:pixel_id"`
+	PosX             uint    `gorm:"column:x; not null; default:0"`
+	PosY             uint    `gorm:"column:y; not null; default:0"`
+	ChatMessageNonce *string `gorm:"column:chat_message; type:varchar(36)"`
+
+	ClaimedBy *uint `gorm:"column:claimed_by"`
+	IsClosed  bool  `gorm:"column:closed; not null; default:false; index:closed"`
+}
+
+// TableName returns the name of the reports table.
+func (*DBReport) TableName() string {
+	return "reports"
+}
+
+// DBAnnouncement represents an alert sent to every connection, as stored in the database
+type DBAnnouncement struct {
+	ID       uint       `gorm:"not null; primary_key; auto_increment"`
+	AuthorID uint       `gorm:"column:who; not null"`
+	Message  string     `gorm:"type:text; not null"`
+	Time     *time.Time `gorm:"type:timestamp; not null; default:now(6)"`
+	Expiry   *time.Time `gorm:"type:timestamp; not null; index:expiry"`
+}
+
+// TableName returns the name of the announcements table.
+func (*DBAnnouncement) TableName() string {
+	return "announcements"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-akka/configuration"
+)
+
+// Heatmap keeps track of when each pixel of the canvas was last placed on.
+// Pixels are at full intensity when placed and decay to zero over Cooldown.
+// It's safe to use from multiple goroutines.
+type Heatmap struct {
+	mu       sync.RWMutex
+	Width    uint
+	Height   uint
+	Cooldown time.Duration
+	// placed holds the time each pixel was placed on in nanoseconds since the epoch,
+	// or 0 if it wasn't placed on within the cooldown.
+	placed []int64
+}
+
+// NewHeatmap creates a new, cold, heatmap with the width and height specified.
+func NewHeatmap(w, h uint, cooldown time.Duration) *Heatmap {
+	return &Heatmap{
+		Width:    w,
+		Height:   h,
+		Cooldown: cooldown,
+		placed:   make([]int64, w*h),
+	}
+}
+
+// Mark sets the pixel as placed on at time t.
+func (hm *Heatmap) Mark(x, y uint, t time.Time) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.placed[x+y*hm.Width] = t.UnixNano()
+}
+
+// Bytes returns the intensity of every pixel at time now,
+// from 0 (cold) to 255 (just placed), in the same layout as the canvas board.
+func (hm *Heatmap) Bytes(now time.Time) []byte {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+
+	var (
+		b        = make([]byte, len(hm.placed))
+		nowNano  = now.UnixNano()
+		cooldown = int64(hm.Cooldown)
+	)
+	if cooldown <= 0 {
+		return b
+	}
+
+	for i, t := range hm.placed {
+		if t == 0 {
+			continue
+		}
+
+		elapsed := nowNano - t
+		if elapsed >= cooldown {
+			continue
+		}
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		b[i] = byte(255 - (255*elapsed)/cooldown)
+	}
+
+	return b
+}
+
+// makeHeatmapFromConf reads width, height and heatmap cooldown from the config file and creates a heatmap
+func makeHeatmapFromConf(conf *configuration.Config) *Heatmap {
+	return NewHeatmap(
+		uint(conf.GetInt32("board.width")),
+		uint(conf.GetInt32("board.height")),
+		conf.GetTimeDurationInfiniteNotAllowed("board.heatmapCooldown", 3*time.Hour),
+	)
+}
+
+// populateHeatmapFromDatabase marks every pixel placed within the heatmap cooldown.
+func populateHeatmapFromDatabase(hm *Heatmap, db *Database) error {
+	return db.EachPixelSince(time.Now().Add(-hm.Cooldown), func(p *DBPixel) {
+		if p.PosX < hm.Width && p.PosY < hm.Height && p.Time != nil {
+			hm.Mark(p.PosX, p.PosY, *p.Time)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrSessionLimit is returned when registering a connection whose owner has too many open
+	ErrSessionLimit = errors.New("too many sessions")
+	// ErrHubClosed is returned when registering a connection after the hub was closed
+	ErrHubClosed = errors.New("hub is closed")
+)
+
+// Hub is the set of all active websocket connections.
+// It's safe to use from multiple goroutines.
+type Hub struct {
+	mu     sync.RWMutex
+	conns  map[*wsConn]struct{}
+	closed bool
+	// handling counts the registered connections which are still being handled,
+	// even after being unregistered, until Done is called for them.
+	handling sync.WaitGroup
+}
+
+// NewHub creates a new, empty, Hub.
+func NewHub() *Hub {
+	return &Hub{conns: make(map[*wsConn]struct{})}
+}
+
+// Register adds the connection to the hub, unless the hub was closed or its owner
+// already has limit or more connections open.
+// Done has to be called once the connection is no longer being handled.
+func (h *Hub) Register(conn *wsConn, limit int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return ErrHubClosed
+	}
+
+	var key = conn.sessionKey()
+	n := 0
+	for other := range h.conns {
+		if other.sessionKey() == key {
+			n++
+		}
+	}
+	if n >= limit {
+		return ErrSessionLimit
+	}
+
+	h.conns[conn] = struct{}{}
+	h.handling.Add(1)
+	return nil
+}
+
+// Unregister removes the connection from the hub.
+func (h *Hub) Unregister(conn *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, conn)
+}
+
+// Done marks a registered connection as no longer being handled.
+func (h *Hub) Done() {
+	h.handling.Done()
+}
+
+// Close stops the hub from registering connections and returns the ones registered.
+func (h *Hub) Close() []*wsConn {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+	return h.All()
+}
+
+// Wait waits until every registered connection is no longer being handled.
+func (h *Hub) Wait() {
+	h.handling.Wait()
+}
+
+// Filter returns every connection for which keep returns true.
+func (h *Hub) Filter(keep func(conn *wsConn) bool) (conns []*wsConn) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for conn := range h.conns {
+		if keep(conn) {
+			conns = append(conns, conn)
+		}
+	}
+	return
+}
+
+// All returns every connection.
+func (h *Hub) All() []*wsConn {
+	return h.Filter(func(*wsConn) bool { return true })
+}
+
+// Broadcast queues the message on every connection.
+// Note(netux): connections are collected first so the hub isn't locked while queueing,
+// which may close connections of clients too slow to keep up.
+func (h *Hub) Broadcast(msg interface{}) {
+	for _, conn := range h.All() {
+		conn.queue(msg)
+	}
+}
+
+// CountUsers returns the amount of different logged in users connected.
+func (h *Hub) CountUsers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make(map[uint]struct{})
+	for conn := range h.conns {
+		if conn.user != nil {
+			ids[conn.user.ID] = struct{}{}
+		}
+	}
+	return len(ids)
+}
+
+// CountOnline returns the amount of different users (or IPs, if not logged in) connected.
+func (h *Hub) CountOnline() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	keys := make(map[string]struct{})
+	for conn := range h.conns {
+		keys[conn.sessionKey()] = struct{}{}
+	}
+	return len(keys)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHubRegisterSessionLimit(t *testing.T) {
+	setupTestApp(t, "")
+	u := newTestUser(t, "alice")
+
+	for i := 0; i < 2; i++ {
+		if err := Connections.Register(newTestConn(t, u), 2); err != nil {
+			t.Fatalf("cannot register connection %d: %v", i, err)
+		}
+	}
+	if err := Connections.Register(newTestConn(t, u), 2); err != ErrSessionLimit {
+		t.Errorf("registering past the session limit returned %v, expected %v", err, ErrSessionLimit)
+	}
+	if err := Connections.Register(newTestConn(t, newTestUser(t, "bob")), 2); err != nil {
+		t.Errorf("cannot register connection of another user: %v", err)
+	}
+}
+
+func TestHubCloseWaitsForConnections(t *testing.T) {
+	setupTestApp(t, "")
+	conn := newTestConn(t, newTestUser(t, "alice"))
+	if err := Connections.Register(conn, 1); err != nil {
+		t.Fatalf("cannot register connection: %v", err)
+	}
+
+	if conns := Connections.Close(); len(conns) != 1 || conns[0] != conn {
+		t.Errorf("Close returned %v, expected only the registered connection", conns)
+	}
+	if err := Connections.Register(newTestConn(t, newTestUser(t, "bob")), 1); err != ErrHubClosed {
+		t.Errorf("registering after closing returned %v, expected %v", err, ErrHubClosed)
+	}
+
+	var waited = make(chan struct{})
+	go func() {
+		Connections.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Wait returned before the connection was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	Connections.Unregister(conn)
+	Connections.Done()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("Wait didn't return after the connection was done")
+	}
+}
+
+// Note(netux): run with -race, most of what this checks is that nothing is reported
+func TestHubConcurrently(t *testing.T) {
+	setupTestApp(t, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u := MakeUser(&DBUser{ID: uint(i + 1), Name: fmt.Sprintf("user%d", i)})
+			for j := 0; j < 10; j++ {
+				conn := newTestConn(t, u)
+				drainMessages(conn)
+				if err := Connections.Register(conn, 5); err != nil {
+					t.Errorf("cannot register connection: %v", err)
+					return
+				}
+				Connections.Broadcast(withType(wsPixelType))
+				Connections.CountUsers()
+				Connections.CountOnline()
+				connectionsOfUser(u.ID)
+				Connections.Unregister(conn)
+				conn.cancel()
+				Connections.Done()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(Connections.All()); n != 0 {
+		t.Errorf("hub has %d connections after unregistering all of them", n)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math/rand"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/go-akka/configuration"
@@ -14,9 +19,6 @@ const (
 	// CanvasBoardFile is the name of the canvas board file
 	CanvasBoardFile = "board.dat"
 
-	// MaxUserAmount is the maximum amount of concurrent users supported by the server
-	MaxUserAmount = 512
-
 	// TODO(netux): lower these values
 
 	// MaxWebsocketReadBufferSize is the maximum size limit of a valid websocket incoming message
@@ -50,62 +52,130 @@ func makeCanvasFromConf(conf *configuration.Config) (*Canvas, error) {
 	), nil
 }
 
+// getStoragePath returns the path of a file in the server.storage directory.
+func getStoragePath(conf *configuration.Config, name string) string {
+	return filepath.Join(conf.GetString("server.storage", "."), name)
+}
+
+// findCanvasBoardFile returns the path of the canvas board file under server.storage,
+// or the one in the working directory if there's only a board file there, in which case legacy is true.
+// Note(netux): boards used to be saved in the working directory, regardless of server.storage.
+func findCanvasBoardFile(conf *configuration.Config) (path string, legacy bool) {
+	path = getStoragePath(conf, CanvasBoardFile)
+	if path == CanvasBoardFile {
+		return path, false
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return path, false
+	}
+	if _, err := os.Stat(CanvasBoardFile); err == nil {
+		return CanvasBoardFile, true
+	}
+	return path, false
+}
+
 // populateCanvasFromFile reads the canvas board file and writes
 // its contents to the canvas board.
-func populateCanvasFromFile(c *Canvas) error {
-	b, err := ioutil.ReadFile(CanvasBoardFile)
+// Boards saved for a canvas of different size, palette or canvas code are refused,
+// unless migrate is set, in which case the part of the board that fits is used.
+func populateCanvasFromFile(c *Canvas, path string, conf *configuration.Config, p Palette, migrate bool) error {
+	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			fmt.Printf("%s not found, using blank board\n", CanvasBoardFile)
+			fmt.Printf("%s not found, using blank board\n", path)
 			return nil
 		}
 		return err
 	}
+	defer f.Close()
+
+	h, b, err := readBoardFile(f)
+	if err != nil {
+		return err
+	}
 
-	if uint(len(b)) > c.Width*c.Height {
-		// TODO(netux): implement input handling to opt-out of this
-		fmt.Printf("saved %s size and canvas configuration differ, which means canvas might be corrupted\n", CanvasBoardFile)
-		fmt.Printf("using %s up to the configured size in 20 seconds unless this program is terminated\n", CanvasBoardFile)
+	var defaultColor = byte(conf.GetInt32("board.defaultColor"))
 
-		<-time.After(20 * time.Second)
+	if h == nil {
+		// Note(netux): legacy board files only hold the canvas board, assume it's as wide as the canvas
+		if uint(len(b)) == c.Width*c.Height {
+			copy(c.Board, b)
+			return nil
+		}
+		if !migrate {
+			return fmt.Errorf("legacy board file %s has %d pixels, but canvas has %d", path, len(b), c.Width*c.Height)
+		}
+		fmt.Printf("migrating legacy board file %s with %d pixels to a canvas with %d\n", path, len(b), c.Width*c.Height)
+		migrateBoard(c, b, c.Width, uint(len(b))/c.Width, len(p), defaultColor)
+		return nil
 	}
 
-	c.Board = b[:c.Width*c.Height]
+	if err := checkBoardMeta(h, makeBoardMeta(conf, c, p)); err != nil {
+		if !migrate {
+			return fmt.Errorf("board file %s doesn't fit the canvas: %v", path, err)
+		}
+		fmt.Printf("migrating board file %s: %v\n", path, err)
+	}
+	migrateBoard(c, b, uint(h.Width), uint(h.Height), len(p), defaultColor)
 	return nil
 }
 
-// saveCanvas writes the contents of the board
-// into the canvas board file
-func saveCanvas(c *Canvas) error {
-	var mode = os.FileMode(644)
-
-	fi, err := os.Stat(CanvasBoardFile)
-	if err == nil {
-		mode = fi.Mode()
-	} else if !os.IsNotExist(err) {
-		return err
+// saveCanvas writes a snapshot of the board into the canvas board file
+// and returns the version of the board saved.
+func saveCanvas(c *Canvas, path string) (uint64, error) {
+	compression, err := getBoardCompression(&App.Conf)
+	if err != nil {
+		return 0, err
 	}
 
-	err = ioutil.WriteFile(CanvasBoardFile, c.Board, mode)
-	return err
+	board, version := c.Snapshot()
+	err = writeFileAtomic(path, 0644, func(w io.Writer) error {
+		return writeBoardFile(w, board, makeBoardMeta(&App.Conf, c, App.Palette), compression)
+	})
+	return version, err
 }
 
-// saveCanvasEvery calls saveCanvas every d time.
-func saveCanvasEvery(c *Canvas, d time.Duration) {
-	err := saveCanvas(c)
+// saveCanvasFiles calls saveCanvas and saveVirginmap
+// and returns the version of the board saved.
+func saveCanvasFiles(c *Canvas, vm *Virginmap) (uint64, error) {
+	version, err := saveCanvas(c, getStoragePath(&App.Conf, CanvasBoardFile))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "save canvas board err: %v", err)
+		return 0, fmt.Errorf("save canvas board err: %v", err)
+	}
+
+	if err := saveVirginmap(vm, getStoragePath(&App.Conf, VirginmapFile)); err != nil {
+		return 0, fmt.Errorf("save virginmap err: %v", err)
 	}
+	return version, nil
+}
 
-	<-time.After(d)
+// saveCanvasEvery calls saveCanvasFiles every d time, if the board changed since it was last saved.
+func saveCanvasEvery(c *Canvas, vm *Virginmap, d time.Duration) {
+	var saved = c.Version()
+	for range time.Tick(d) {
+		if c.Version() == saved {
+			continue
+		}
 
-	saveCanvasEvery(c, d)
+		version, err := saveCanvasFiles(c, vm)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			continue
+		}
+		saved = version
+	}
 }
 
 // App stores globally accesible information about the game application
 var App PxlsApp
 
+var restoreFlag = flag.String("restore", "", "name of a backup in the backups directory, or path to a board file, to load instead of the saved board")
+var migrateBoardFlag = flag.Bool("migrate-board", false, "load the saved board even if it was saved with a different size, palette or canvas code")
+
 func main() {
+	flag.Parse()
+	rand.Seed(time.Now().UnixNano())
+
 	conf, err := ReadConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "reading config err: %v\n", err)
@@ -122,7 +192,6 @@ func main() {
 		fmt.Fprintf(os.Stderr, "initializing database err: %v\n", err)
 		return
 	}
-	defer db.Close()
 
 	palette, err := makePaletteFromConf(conf)
 	if err != nil {
@@ -135,11 +204,84 @@ func main() {
 		fmt.Fprintf(os.Stderr, "canvas parsing from config err: %v\n", err)
 		return
 	}
-	populateCanvasFromFile(canvas)
+	// saveBoardNow is whenever the board was loaded from somewhere else than
+	// the canvas board file, and has to be saved there before anything else happens.
+	var saveBoardNow bool
+	if *restoreFlag != "" {
+		if err := restoreBackup(canvas, conf, palette, *restoreFlag, *migrateBoardFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "restoring backup err: %v\n", err)
+			return
+		}
+		fmt.Printf("restored board from backup %s\n", *restoreFlag)
+		saveBoardNow = true
+	} else {
+		boardPath, legacy := findCanvasBoardFile(conf)
+		if legacy {
+			fmt.Fprintf(os.Stderr, "WARNING: %s not found, loading the board from %s in the working directory instead, it will be saved to %s from now on\n",
+				getStoragePath(conf, CanvasBoardFile), boardPath, getStoragePath(conf, CanvasBoardFile))
+			saveBoardNow = true
+		}
+		if err := populateCanvasFromFile(canvas, boardPath, conf, palette, *migrateBoardFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "canvas populating err: %v\n", err)
+			return
+		}
+	}
+
+	virginmap := NewVirginmap(canvas.Width, canvas.Height)
+	if err := populateVirginmapFromFile(virginmap, getStoragePath(conf, VirginmapFile), db); err != nil {
+		fmt.Fprintf(os.Stderr, "virginmap populating err: %v\n", err)
+	}
 
-	App = PxlsApp{*conf, *db, *canvas, palette, *MakeUserList()}
+	heatmap := makeHeatmapFromConf(conf)
+	if err := populateHeatmapFromDatabase(heatmap, db); err != nil {
+		fmt.Fprintf(os.Stderr, "heatmap populating from database err: %v\n", err)
+	}
 
-	go saveCanvasEvery(canvas, conf.GetTimeDurationInfiniteNotAllowed("board.saveInterval", 5*time.Second))
+	chatFilter, err := makeChatFilterFromConf(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "chat filter parsing from config err: %v\n", err)
+		return
+	}
+
+	App = PxlsApp{
+		Conf:       *conf,
+		DB:         *db,
+		Canvas:     canvas,
+		Heatmap:    heatmap,
+		Virginmap:  virginmap,
+		Palette:    palette,
+		Users:      MakeUserList(),
+		Limits:     makeRateLimitsFromConf(conf),
+		ChatFilter: chatFilter,
+
+		AuthProviders: makeOAuthProvidersFromConf(conf),
+		Captcha:       makeCaptchaVerifierFromConf(conf),
+	}
+
+	if saveBoardNow {
+		if _, err := saveCanvas(canvas, getStoragePath(conf, CanvasBoardFile)); err != nil {
+			fmt.Fprintf(os.Stderr, "save canvas board err: %v\n", err)
+			return
+		}
+	}
+
+	go saveCanvasEvery(canvas, virginmap, conf.GetTimeDurationInfiniteNotAllowed("board.saveInterval", 5*time.Second))
+	go backupCanvasEvery(canvas, conf.GetTimeDurationInfiniteNotAllowed("board.backupInterval", 5*time.Minute))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- StartServer()
+	}()
+
+	select {
+	case sig := <-signals:
+		fmt.Printf("received %v, shutting down\n", sig)
+	case err := <-serverErr:
+		fmt.Fprintf(os.Stderr, "server err: %v\n", err)
+	}
 
-	StartServer()
+	Shutdown(conf.GetTimeDurationInfiniteNotAllowed("server.shutdownTimeout", 10*time.Second))
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-akka/configuration"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// testConf is the config used by setupTestApp, before the extra config given to it.
+const testConf = `
+board {
+  width: 16
+  height: 16
+  defaultColor: 0
+  palette: ["#FFFFFF", "#000000", "#FF0000", "#00FF00"]
+}
+cooldown: 3m
+stacking {
+  cooldownMultiplier: 3
+  maxStacked: 5
+}
+oauth {
+  enableRegistration: true
+}
+`
+
+// newTestDatabase creates a database in memory with every table.
+func newTestDatabase(t *testing.T) *Database {
+	conn, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("cannot open test database: %v", err)
+	}
+	// Note(netux): every connection to :memory: gets its own database, so only use one
+	conn.DB().SetMaxOpenConns(1)
+
+	// Note(netux): SQLite doesn't know now(), use CURRENT_TIMESTAMP instead
+	for _, m := range dbModels {
+		for _, f := range conn.NewScope(m).GetModelStruct().StructFields {
+			if d, ok := f.TagSettingsGet("DEFAULT"); ok && strings.HasPrefix(d, "now(") {
+				f.TagSettingsSet("DEFAULT", "CURRENT_TIMESTAMP")
+			}
+		}
+	}
+	if err := conn.AutoMigrate(dbModels...).Error; err != nil {
+		t.Fatalf("cannot create test database tables: %v", err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	return &Database{sql: conn, driver: "sqlite3"}
+}
+
+// setupTestApp replaces App with one using a test database and the test config,
+// followed by extraConf.
+func setupTestApp(t *testing.T, extraConf string) {
+	conf := configuration.ParseString(testConf + extraConf)
+
+	palette, err := makePaletteFromConf(conf)
+	if err != nil {
+		t.Fatalf("cannot parse test palette: %v", err)
+	}
+	canvas, err := makeCanvasFromConf(conf)
+	if err != nil {
+		t.Fatalf("cannot create test canvas: %v", err)
+	}
+
+	App = PxlsApp{
+		Conf:      *conf,
+		DB:        *newTestDatabase(t),
+		Canvas:    canvas,
+		Heatmap:   makeHeatmapFromConf(conf),
+		Virginmap: NewVirginmap(canvas.Width, canvas.Height),
+		Palette:   palette,
+		Users:     MakeUserList(),
+		Limits:    makeRateLimitsFromConf(conf),
+
+		AuthProviders: makeOAuthProvidersFromConf(conf),
+	}
+	Connections = NewHub()
+}
+
+// newTestUser creates an user in the database with the given name and caches it.
+func newTestUser(t *testing.T, name string) *User {
+	dbUser, err := App.DB.CreateUser(name, UserLogin{"test", name}, "192.0.2.1", "")
+	if err != nil {
+		t.Fatalf("cannot create test user: %v", err)
+	}
+
+	u, err := App.Users.MakeAndAdd(dbUser, name)
+	if err != nil {
+		t.Fatalf("cannot cache test user: %v", err)
+	}
+	t.Cleanup(func() { App.Users.RemoveByID(u.ID) })
+	return u
+}
+
+// newTestConn creates a connection of the user without a websocket behind it,
+// which keeps the messages queued on it until they are taken with takeMessages.
+func newTestConn(t *testing.T, u *User) *wsConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return &wsConn{
+		ctx:       ctx,
+		cancel:    cancel,
+		user:      u,
+		ip:        "192.0.2.1",
+		sendQueue: make(chan interface{}, 1024),
+	}
+}
+
+// takeMessages returns every message queued on a connection created with newTestConn.
+func takeMessages(conn *wsConn) (msgs []interface{}) {
+	for {
+		select {
+		case msg := <-conn.sendQueue:
+			msgs = append(msgs, msg)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-akka/configuration"
+)
+
+// RateLimiter limits how many times something identified by a key
+// (usually an IP or an user ID) can be done within a time frame.
+type RateLimiter struct {
+	Count uint
+	Time  time.Duration
+
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+// Allow registers a hit for the key and returns whenever
+// the key hasn't gone over the limit.
+func (rl *RateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	var now = time.Now()
+	rl.sweep(now)
+
+	hits := rl.hits[key][:0]
+	for _, t := range rl.hits[key] {
+		if now.Sub(t) < rl.Time {
+			hits = append(hits, t)
+		}
+	}
+
+	if uint(len(hits)) >= rl.Count {
+		if len(hits) == 0 {
+			delete(rl.hits, key)
+		} else {
+			rl.hits[key] = hits
+		}
+		return false
+	}
+
+	rl.hits[key] = append(hits, now)
+	return true
+}
+
+// sweep deletes the keys whose hits have all expired, at most once every rl.Time,
+// so keys which are never hit again don't stay around forever.
+// The caller must hold rl.mu.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rl.Time {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, hits := range rl.hits {
+		// Note(netux): hits are appended in order, so the last one expires last
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= rl.Time {
+			delete(rl.hits, key)
+		}
+	}
+}
+
+// RetryAfter returns how much time is left until the key can hit again.
+func (rl *RateLimiter) RetryAfter(key string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	hits := rl.hits[key]
+	if uint(len(hits)) < rl.Count {
+		return 0
+	}
+	if rl.Count == 0 {
+		return rl.Time
+	}
+
+	// Note(netux): hits are appended in order, so the first one expires first
+	if d := time.Until(hits[len(hits)-int(rl.Count)].Add(rl.Time)); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// MakeRateLimiter creates a RateLimiter which allows count hits every t time.
+func MakeRateLimiter(count uint, t time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Count: count,
+		Time:  t,
+		hits:  make(map[string][]time.Time),
+	}
+}
+
+// RateLimits contains rate limiters stored by name (e.g. "lookup").
+type RateLimits map[string]*RateLimiter
+
+// Allow registers a hit for the key in the rate limiter with
+// the given name. Unknown rate limiters always allow.
+func (rls RateLimits) Allow(name, key string) bool {
+	rl, ok := rls[name]
+	if !ok {
+		return true
+	}
+	return rl.Allow(key)
+}
+
+// RetryAfter returns how much time is left until the key can hit again
+// in the rate limiter with the given name.
+func (rls RateLimits) RetryAfter(name, key string) time.Duration {
+	rl, ok := rls[name]
+	if !ok {
+		return 0
+	}
+	return rl.RetryAfter(key)
+}
+
+// makeRateLimitsFromConf creates a rate limiter for
+// every entry in the server.limits block of the config file.
+func makeRateLimitsFromConf(conf *configuration.Config) RateLimits {
+	var rls = make(RateLimits)
+
+	limits := conf.GetNode("server.limits")
+	if limits == nil || !limits.IsObject() {
+		return rls
+	}
+
+	for _, name := range limits.GetObject().GetKeys() {
+		path := "server.limits." + name
+		rls[name] = MakeRateLimiter(
+			uint(conf.GetInt32(path+".count")),
+			conf.GetTimeDurationInfiniteNotAllowed(path+".time"),
+		)
+	}
+
+	return rls
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxReportMessageLength is the maximum length of the message of a report,
+// the size of the message column.
+const MaxReportMessageLength = 2048
+
+type apiReport struct {
+	ID               uint    `json:"id"`
+	ReporterID       uint    `json:"reporter"`
+	ReportedID       uint    `json:"reported"`
+	Message          string  `json:"message"`
+	Time             int64   `json:"time"`
+	PixelID          *uint   `json:"pixel_id,omitempty"`
+	PosX             uint    `json:"x"`
+	PosY             uint    `json:"y"`
+	ChatMessageNonce *string `json:"chat_message,omitempty"`
+	ClaimedBy        *uint   `json:"claimed_by"`
+}
+
+func makeAPIReport(r *DBReport) apiReport {
+	res := apiReport{
+		ID:               r.ID,
+		ReporterID:       r.ReporterID,
+		ReportedID:       r.ReportedID,
+		Message:          r.Message,
+		PixelID:          r.PixelID,
+		PosX:             r.PosX,
+		PosY:             r.PosY,
+		ChatMessageNonce: r.ChatMessageNonce,
+		ClaimedBy:        r.ClaimedBy,
+	}
+	if r.Time != nil {
+		res.Time = r.Time.UnixNano() / int64(time.Millisecond)
+	}
+	return res
+}
+
+// getReportMessage validates and returns the message of a report.
+func getReportMessage(msg string) (string, error) {
+	msg = strings.TrimSpace(msg)
+	if msg == "" {
+		return "", fmt.Errorf("report message is empty")
+	}
+	if utf8.RuneCountInString(msg) > MaxReportMessageLength {
+		msg = string([]rune(msg)[:MaxReportMessageLength])
+	}
+	return msg, nil
+}
+
+// startReport checks the request can make a report and returns the reporter.
+func startReport(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	if r.Method != "POST" {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return nil, false
+	}
+
+	u, ok := requireReqUser(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if !App.Limits.Allow("report", fmt.Sprint(u.ID)) {
+		writeAPIError(w, http.StatusTooManyRequests, "You are reporting too fast, please try again later")
+		return nil, false
+	}
+
+	return u, true
+}
+
+// handleReport reports the pixel with the given ID at the given position.
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	u, ok := startReport(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(r.PostFormValue("id"), 10, 32)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid pixel ID")
+		return
+	}
+	x, errX := strconv.ParseUint(r.PostFormValue("x"), 10, 32)
+	y, errY := strconv.ParseUint(r.PostFormValue("y"), 10, 32)
+	if errX != nil || errY != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid position")
+		return
+	}
+
+	msg, err := getReportMessage(r.PostFormValue("message"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pixel, err := App.DB.GetPixelByID(uint(id))
+	if err != nil {
+		if IsNotFoundError(err) {
+			writeAPIError(w, http.StatusNotFound, "Pixel not found")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "cannot fetch pixel with ID %d from database: %v\n", id, err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot report pixel")
+		return
+	}
+	if pixel.PosX != uint(x) || pixel.PosY != uint(y) {
+		writeAPIError(w, http.StatusBadRequest, "Pixel is not at the given position")
+		return
+	}
+
+	err = App.DB.CreateReport(&DBReport{
+		ReporterID: u.ID,
+		ReportedID: pixel.PlacerID,
+		Message:    msg,
+		PixelID:    &pixel.ID,
+		PosX:       pixel.PosX,
+		PosY:       pixel.PosY,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot save report of pixel with ID %d by user with ID %d in database: %v\n", pixel.ID, u.ID, err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot report pixel")
+	}
+}
+
+// handleReportChat reports the chat message with the given nonce.
+func handleReportChat(w http.ResponseWriter, r *http.Request) {
+	u, ok := startReport(w, r)
+	if !ok {
+		return
+	}
+
+	msg, err := getReportMessage(r.PostFormValue("report_message"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	nonce := r.PostFormValue("nonce")
+	chatMsg, err := App.DB.GetChatMessageByNonce(nonce)
+	if err != nil {
+		if IsNotFoundError(err) {
+			writeAPIError(w, http.StatusNotFound, "Chat message not found")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "cannot fetch chat message with nonce %s from database: %v\n", nonce, err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot report chat message")
+		return
+	}
+
+	err = App.DB.CreateReport(&DBReport{
+		ReporterID:       u.ID,
+		ReportedID:       chatMsg.AuthorID,
+		Message:          msg,
+		ChatMessageNonce: &chatMsg.Nonce,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot save report of chat message with nonce %s by user with ID %d in database: %v\n", nonce, u.ID, err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot report chat message")
+	}
+}
+
+// handleAdminReports responds with every open report.
+func handleAdminReports(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requirePermission(w, r, ReportsPermission); !ok {
+		return
+	}
+
+	reports, err := App.DB.GetOpenReports()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot fetch open reports from database: %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot fetch reports")
+		return
+	}
+
+	res := make([]apiReport, len(reports))
+	for i, report := range reports {
+		res[i] = makeAPIReport(report)
+	}
+
+	w.Header().Set("Content-Type", "text/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// handleAdminReportAction returns an http.HandleFunc which calls action
+// with the ID of the report in the request and the moderator's ID.
+func handleAdminReportAction(action func(id, modID uint) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		mod, ok := requirePermission(w, r, ReportsPermission)
+		if !ok {
+			return
+		}
+
+		id, err := strconv.ParseUint(r.PostFormValue("id"), 10, 32)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Invalid report ID")
+			return
+		}
+
+		if err := action(uint(id), mod.ID); err != nil {
+			if IsNotFoundError(err) {
+				writeAPIError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			fmt.Fprintf(os.Stderr, "cannot update report with ID %d in database: %v\n", id, err)
+			writeAPIError(w, http.StatusInternalServerError, "Cannot update report")
+		}
+	}
+}
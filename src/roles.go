@@ -0,0 +1,116 @@
+package main
+
+// UserRole is the role of an user
+type UserRole string
+
+const (
+	// GuestUserRole is the role of an user which can only look at the canvas
+	GuestUserRole = "GUEST"
+	// DefaultUserRole is the role an user which can only
+	// place pixels, lookup pixels, report other users, and chat
+	DefaultUserRole = "USER"
+	// TrialModUserRole is the role of a moderator in probation,
+	// which can handle reports and temporarily ban users
+	TrialModUserRole = "TRIALMOD"
+	// ModeratorUserRole is the role of an user which can moderate the canvas and chat
+	ModeratorUserRole = "MODERATOR"
+	// DeveloperUserRole is the role of an user which can use the canvas tools
+	DeveloperUserRole = "DEVELOPER"
+	// AdminUserRole is the role of an user which can do everything, including assigning roles
+	AdminUserRole = "ADMIN"
+
+	// BannedUserRole is the role of a permanently banned user
+	BannedUserRole = "BANNED"
+	// ShadowbannedUserRole is the role of an user whose
+	// pixels are only shown to themselves
+	ShadowbannedUserRole = "SHADOWBANNED"
+)
+
+// roleLevels holds the position of each role in the hierarchy.
+// Roles inherit the permissions of every role with a lower level.
+var roleLevels = map[UserRole]int{
+	BannedUserRole:       0,
+	GuestUserRole:        0,
+	ShadowbannedUserRole: 1,
+	DefaultUserRole:      1,
+	TrialModUserRole:     2,
+	ModeratorUserRole:    3,
+	DeveloperUserRole:    4,
+	AdminUserRole:        5,
+}
+
+// Level returns the position of the role in the hierarchy, or 0 if the role is unknown.
+func (r UserRole) Level() int {
+	return roleLevels[r]
+}
+
+// IsValid returns whenever the role is a known one.
+func (r UserRole) IsValid() bool {
+	_, ok := roleLevels[r]
+	return ok
+}
+
+// IsBan returns whenever the role is one given to (shadow)banned users.
+func (r UserRole) IsBan() bool {
+	return r == BannedUserRole || r == ShadowbannedUserRole
+}
+
+// IsStaff returns whenever the role can moderate the canvas.
+func (r UserRole) IsStaff() bool {
+	return r.Level() >= UserRole(TrialModUserRole).Level()
+}
+
+// Permission is an action only some roles are allowed to do
+type Permission string
+
+const (
+	// LookupDetailPermission allows seeing the login and user agent of placers on lookups
+	LookupDetailPermission Permission = "lookup.detail"
+	// ReportsPermission allows seeing, claiming and resolving reports
+	ReportsPermission Permission = "reports"
+	// BanPermission allows temporarily banning, unbanning and checking users
+	BanPermission Permission = "ban"
+	// PermabanPermission allows permanently banning users
+	PermabanPermission Permission = "ban.permanent"
+	// ShadowbanPermission allows shadowbanning users
+	ShadowbanPermission Permission = "ban.shadow"
+	// ChatModerationPermission allows chat banning users and deleting chat messages
+	ChatModerationPermission Permission = "chat.moderation"
+	// AlertsPermission allows sending alerts to users and announcements to everyone
+	AlertsPermission Permission = "alerts"
+	// CooldownOverridePermission allows placing without cooldown
+	CooldownOverridePermission Permission = "cooldown.override"
+	// RollbackPermission allows rolling back the pixels of an user
+	RollbackPermission Permission = "rollback"
+	// CanvasToolsPermission allows filling and restoring areas of the canvas
+	CanvasToolsPermission Permission = "canvas.tools"
+	// RolesPermission allows assigning roles to users
+	RolesPermission Permission = "roles"
+)
+
+// permissionRoles holds the lowest role each permission is granted to.
+var permissionRoles = map[Permission]UserRole{
+	LookupDetailPermission:     TrialModUserRole,
+	ReportsPermission:          TrialModUserRole,
+	BanPermission:              TrialModUserRole,
+	PermabanPermission:         ModeratorUserRole,
+	ShadowbanPermission:        AdminUserRole,
+	ChatModerationPermission:   TrialModUserRole,
+	AlertsPermission:           ModeratorUserRole,
+	CooldownOverridePermission: ModeratorUserRole,
+	RollbackPermission:         ModeratorUserRole,
+	CanvasToolsPermission:      DeveloperUserRole,
+	RolesPermission:            AdminUserRole,
+}
+
+// Can returns whenever the role is granted the permission.
+func (r UserRole) Can(p Permission) bool {
+	min, ok := permissionRoles[p]
+	return ok && r.Level() >= min.Level()
+}
+
+// hasPermission returns whenever the user is logged in and granted the permission.
+// It's used by both HTTP and websocket handlers.
+func hasPermission(u *User, p Permission) bool {
+	return u != nil && App.Users.Snapshot(u.DBUser).Role.Can(p)
+}
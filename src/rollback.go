@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// applyPixels sets the color of every pixel on the canvas and broadcasts them in a single message.
+func applyPixels(pixels []*DBPixel) {
+	if len(pixels) == 0 {
+		return
+	}
+
+	wsPixels := make([]wsPixel, len(pixels))
+	for i, p := range pixels {
+		App.Canvas.SetPixelColor(p.PosX, p.PosY, p.ColorIdx)
+		wsPixels[i] = wsPixel{p.PosX, p.PosY, p.ColorIdx}
+	}
+	broadcastPixels(wsPixels...)
+}
+
+// rollbackUser reverts the pixels placed by the user with the given ID within the last d.
+func rollbackUser(mod *User, uid uint, d time.Duration) error {
+	pixels, err := App.DB.RollbackUser(mod, uid, time.Now().Add(-d))
+	if err != nil {
+		return err
+	}
+	applyPixels(pixels)
+	return nil
+}
+
+// undoRollbackUser restores the pixels placed by the user with the given ID
+// within the last d that were reverted by a rollback.
+func undoRollbackUser(mod *User, uid uint, d time.Duration) error {
+	pixels, err := App.DB.UndoRollbackUser(mod, uid, time.Now().Add(-d))
+	if err != nil {
+		return err
+	}
+	applyPixels(pixels)
+	return nil
+}
+
+// getRollbackDuration parses the seconds in the given form value of the request.
+func getRollbackDuration(r *http.Request, key string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(r.PostFormValue(key), 64)
+	if err != nil || secs < 0 {
+		return 0, fmt.Errorf("invalid rollback time")
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// handleAdminRollbackAction returns an http.HandlerFunc which calls action with the moderator, the ID of
+// the user in the username form value and the duration in the time form value, in seconds.
+func handleAdminRollbackAction(action func(mod *User, uid uint, d time.Duration) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mod, target, ok := startAdminAction(w, r, RollbackPermission)
+		if !ok {
+			return
+		}
+
+		d, err := getRollbackDuration(r, "time")
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Invalid rollback time")
+			return
+		}
+
+		if !outranks(w, mod, target) {
+			return
+		}
+
+		if err := action(mod, target.ID, d); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot rollback pixels of user with ID %d in database: %v\n", target.ID, err)
+			writeAPIError(w, http.StatusInternalServerError, "Cannot rollback pixels")
+		}
+	}
+}
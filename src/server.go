@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 )
 
 type apiAuthServices struct {
@@ -24,8 +27,61 @@ type apiInfo struct {
 }
 
 type apiWhoAmI struct {
-	Name string `json:"string"`
-	ID   int    `json:"id"`
+	Name string `json:"username"`
+	ID   uint   `json:"id"`
+}
+
+type apiLookup struct {
+	ID                uint   `json:"id"`
+	PosX              uint   `json:"x"`
+	PosY              uint   `json:"y"`
+	Username          string `json:"username"`
+	Time              int64  `json:"time"`
+	PixelCount        uint64 `json:"pixel_count"`
+	PixelCountAlltime uint64 `json:"pixel_count_alltime"`
+
+	// Only sent to users granted LookupDetailPermission
+	Login     string `json:"login,omitempty"`
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// writeAPIError responds with the status code and a JSON body holding the message.
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{msg})
+}
+
+// requireReqUser returns the user making the request, or responds
+// with 401 Unauthorized if there's none.
+func requireReqUser(w http.ResponseWriter, r *http.Request) (*User, bool) {
+	u, err := getReqUser(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot get user of request: %v\n", err)
+	}
+	if u == nil {
+		writeAPIError(w, http.StatusUnauthorized, "Not logged in")
+		return nil, false
+	}
+	return u, true
+}
+
+// requirePermission returns the user making the request if they are granted
+// the permission, or responds with 401 Unauthorized or 403 Forbidden otherwise.
+func requirePermission(w http.ResponseWriter, r *http.Request, p Permission) (*User, bool) {
+	u, ok := requireReqUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !hasPermission(u, p) {
+		writeAPIError(w, http.StatusForbidden, "Insufficient permissions")
+		return nil, false
+	}
+	return u, true
 }
 
 func intToHex(cs []int) []string {
@@ -38,24 +94,30 @@ func intToHex(cs []int) []string {
 
 // TODO(netux): replace fmt with a logger
 
+// HTTPServer is the server StartServer listens and serves with.
+var HTTPServer = &http.Server{}
+
 // StartServer sets up endpoint handlers and listens and serves
-func StartServer() {
+// until HTTPServer is shut down.
+func StartServer() error {
 	// handle /info
 	http.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
 		info := apiInfo{
-			CanvasCode:       App.Conf.GetString("canvascode"),
-			Width:            App.Canvas.Width,
-			Height:           App.Canvas.Height,
-			Palette:          intToHex(App.Palette),
-			MaxStackedPixels: uint(App.Conf.GetInt32("stacking.maxStacked")),
-			// TODO(netux): return actually supported auth services
-			AuthServices: map[string]apiAuthServices{
-				"discord": apiAuthServices{
-					ID:   "discord",
-					Name: "Discord",
-				},
-			},
-			RegistrationEnabled: false,
+			CanvasCode:          App.Conf.GetString("canvascode"),
+			Width:               App.Canvas.Width,
+			Height:              App.Canvas.Height,
+			Palette:             intToHex(App.Palette),
+			CaptchaKey:          getCaptchaKey(),
+			HeatmapCooldown:     int(App.Heatmap.Cooldown / time.Second),
+			MaxStackedPixels:    uint(App.Conf.GetInt32("stacking.maxStacked")),
+			AuthServices:        make(map[string]apiAuthServices),
+			RegistrationEnabled: isRegistrationEnabled(),
+		}
+		for id, p := range App.AuthProviders {
+			info.AuthServices[id] = apiAuthServices{
+				ID:   p.ID(),
+				Name: p.Name(),
+			}
 		}
 
 		w.Header().Set("Content-Type", "text/json")
@@ -64,15 +126,65 @@ func StartServer() {
 
 	// handle /boarddata
 	http.HandleFunc("/boarddata", func(w http.ResponseWriter, r *http.Request) {
-		w.Write(App.Canvas.Board)
+		board, _ := App.Canvas.Snapshot()
+		w.Write(board)
 	})
 
-	// handle /whoami
-	http.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
-		var res = apiWhoAmI{"-snip-", -1}
-		json.NewEncoder(w).Encode(res)
+	// handle /heatmap
+	http.HandleFunc("/heatmap", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(App.Heatmap.Bytes(time.Now()))
+	})
+
+	// handle /virginmap
+	http.HandleFunc("/virginmap", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(App.Virginmap.Snapshot())
 	})
 
+	// handle /whoami
+	http.HandleFunc("/whoami", handleWhoAmI)
+
+	// handle /signin/{id} and /auth/{id}
+	http.HandleFunc("/signin/", handleSignin)
+	http.HandleFunc("/auth/", handleAuthCallback)
+
+	// handle /logout
+	http.HandleFunc("/logout", handleLogout)
+
+	// handle /signup
+	http.HandleFunc("/signup", handleSignup)
+
+	// handle /lookup
+	http.HandleFunc("/lookup", handleLookup)
+
+	// handle /report and /reportChat
+	http.HandleFunc("/report", handleReport)
+	http.HandleFunc("/reportChat", handleReportChat)
+
+	// handle /admin/reports
+	http.HandleFunc("/admin/reports", handleAdminReports)
+	http.HandleFunc("/admin/reports/claim", handleAdminReportAction(App.DB.ClaimReport))
+	http.HandleFunc("/admin/reports/resolve", handleAdminReportAction(App.DB.ResolveReport))
+
+	// handle /admin/ban, /admin/permaban, /admin/shadowban, /admin/unban and /admin/check
+	http.HandleFunc("/admin/ban", handleAdminBan)
+	http.HandleFunc("/admin/permaban", handleAdminPermaban)
+	http.HandleFunc("/admin/shadowban", handleAdminShadowban)
+	http.HandleFunc("/admin/unban", handleAdminUnban)
+	http.HandleFunc("/admin/check", handleAdminCheck)
+
+	// handle /admin/rollback and /admin/rollback/undo
+	http.HandleFunc("/admin/rollback", handleAdminRollbackAction(rollbackUser))
+	http.HandleFunc("/admin/rollback/undo", handleAdminRollbackAction(undoRollbackUser))
+
+	// handle /admin/fill
+	http.HandleFunc("/admin/fill", handleAdminFill)
+
+	// handle /admin/role
+	http.HandleFunc("/admin/role", handleAdminRole)
+
+	// handle /admin/alert
+	http.HandleFunc("/admin/alert", handleAdminAlert)
+
 	// handle /ws
 	http.HandleFunc("/ws", HandleWebsocketPath)
 
@@ -80,6 +192,105 @@ func StartServer() {
 	fs := http.FileServer(http.Dir("static"))
 	http.Handle("/", fs)
 
-	port := App.Conf.GetString("server.port")
-	http.ListenAndServe(":"+port, nil)
+	HTTPServer.Addr = ":" + App.Conf.GetString("server.port")
+	return HTTPServer.ListenAndServe()
+}
+
+// handleWhoAmI responds with the name and ID of the user making the request.
+// Sites in whoamiAllowedOrigin can make this request with the user's credentials.
+func handleWhoAmI(w http.ResponseWriter, r *http.Request) {
+	if origin := App.Conf.GetString("whoamiAllowedOrigin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Vary", "Origin")
+	}
+
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	u, ok := requireReqUser(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/json")
+	json.NewEncoder(w).Encode(apiWhoAmI{u.Name, u.ID})
+}
+
+// getReqPosition parses the x and y query parameters of the request
+// and checks that they are inside the canvas.
+func getReqPosition(r *http.Request) (x, y uint, err error) {
+	q := r.URL.Query()
+
+	px, err := strconv.ParseUint(q.Get("x"), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid x position: %v", err)
+	}
+	py, err := strconv.ParseUint(q.Get("y"), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid y position: %v", err)
+	}
+
+	x, y = uint(px), uint(py)
+	if x >= App.Canvas.Width || y >= App.Canvas.Height {
+		return 0, 0, fmt.Errorf("position (%d, %d) is outside of the canvas", x, y)
+	}
+
+	return x, y, nil
+}
+
+// handleLookup responds with information about the most recent pixel
+// placed at the requested position, or null if it was never placed.
+func handleLookup(w http.ResponseWriter, r *http.Request) {
+	ip, err := getReqIP(r)
+	if err != nil {
+		http.Error(w, "cannot get IP address", http.StatusBadRequest)
+		return
+	}
+
+	if !App.Limits.Allow("lookup", ip) {
+		http.Error(w, "too many lookups", http.StatusTooManyRequests)
+		return
+	}
+
+	x, y, err := getReqPosition(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/json")
+
+	l, err := App.DB.GetLookupAt(x, y)
+	if err != nil {
+		if !IsNotFoundError(err) {
+			fmt.Fprintf(os.Stderr, "cannot lookup pixel at (%d, %d): %v\n", x, y, err)
+			http.Error(w, "cannot lookup pixel", http.StatusInternalServerError)
+			return
+		}
+
+		// Note(netux): the client treats null as "background pixel"
+		json.NewEncoder(w).Encode(nil)
+		return
+	}
+
+	res := apiLookup{
+		ID:                l.ID,
+		PosX:              l.PosX,
+		PosY:              l.PosY,
+		Username:          l.Username,
+		PixelCount:        l.PixelCount,
+		PixelCountAlltime: l.PixelCountAlltime,
+	}
+	if u, _ := getReqUser(r); hasPermission(u, LookupDetailPermission) {
+		res.Login = l.Login
+		res.UserAgent = l.UserAgent
+	}
+	if l.Time != nil {
+		res.Time = l.Time.UnixNano() / int64(time.Millisecond)
+	}
+	json.NewEncoder(w).Encode(res)
 }
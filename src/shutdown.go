@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var shuttingDown int32
+
+// isShuttingDown returns whenever Shutdown was called, after which no more pixels can be placed.
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// Shutdown stops accepting placements and requests, closes every websocket connection,
+// saves the users' stacked pixels and the canvas, and closes the database.
+// It gives up on whatever is left once timeout has passed.
+func Shutdown(timeout time.Duration) {
+	atomic.StoreInt32(&shuttingDown, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		if err := HTTPServer.Shutdown(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "http server shutdown err: %v\n", err)
+		}
+
+		// Note(netux): hijacked connections aren't closed by http.Server.Shutdown
+		for _, conn := range Connections.Close() {
+			conn.close(websocket.CloseGoingAway, "server shutting down")
+		}
+		// Note(netux): connections save their user's stacked pixels once they're closed,
+		// which has to happen before the database is closed
+		Connections.Wait()
+
+		App.Users.SaveStackers()
+
+		if _, err := saveCanvasFiles(App.Canvas, App.Virginmap); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+
+		if err := App.DB.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "database close err: %v\n", err)
+		}
+	}()
+
+	select {
+	case <-done:
+		fmt.Println("shut down")
+	case <-ctx.Done():
+		fmt.Fprintf(os.Stderr, "shutdown didn't finish within %v\n", timeout)
+	}
+}
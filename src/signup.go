@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SignupTokenLength is the length of the tokens given to users who are signing up
+	SignupTokenLength = 32
+	// SignupTokenLifetime is how long users have to pick an username after signing in
+	SignupTokenLifetime = 15 * time.Minute
+
+	// MinUsernameLength is the minimum length of an username
+	MinUsernameLength = 1
+	// MaxUsernameLength is the maximum length of an username, the size of the username column
+	MaxUsernameLength = 32
+
+	// IPUsernamePrefix starts the username of users signed in by IP, which no one can sign up with
+	IPUsernamePrefix = "-snip-"
+)
+
+var usernameRegexp = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// ValidateUsername returns an error describing why the username
+// is not valid, or nil if it is.
+func ValidateUsername(name string) error {
+	if len(name) < MinUsernameLength || len(name) > MaxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters long", MinUsernameLength, MaxUsernameLength)
+	}
+	if !usernameRegexp.MatchString(name) {
+		return fmt.Errorf("username can only contain letters, numbers, underscores and dashes")
+	}
+	if strings.HasPrefix(name, IPUsernamePrefix) {
+		return fmt.Errorf("username is reserved")
+	}
+	return nil
+}
+
+type pendingSignup struct {
+	login   UserLogin
+	expires time.Time
+}
+
+// SignupList contains logins of users who signed in with
+// a provider but have yet to pick an username, stored by token.
+type SignupList struct {
+	mu      sync.Mutex
+	byToken map[string]pendingSignup
+}
+
+// Add stores the login and returns the token the user has to sign up with.
+func (l *SignupList) Add(login UserLogin) (string, error) {
+	token, err := GenerateToken(SignupTokenLength)
+	if err != nil {
+		return "", err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for t, ps := range l.byToken {
+		if now.After(ps.expires) {
+			delete(l.byToken, t)
+		}
+	}
+	l.byToken[token] = pendingSignup{login, now.Add(SignupTokenLifetime)}
+
+	return token, nil
+}
+
+// Take returns the login stored with the token and removes it.
+func (l *SignupList) Take(token string) (login UserLogin, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ps, ok := l.byToken[token]
+	if !ok || time.Now().After(ps.expires) {
+		return UserLogin{}, false
+	}
+
+	delete(l.byToken, token)
+	return ps.login, true
+}
+
+// Restore stores the login again with the same token, so the user can retry.
+func (l *SignupList) Restore(token string, login UserLogin) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.byToken[token] = pendingSignup{login, time.Now().Add(SignupTokenLifetime)}
+}
+
+// MakeSignupList creates a new SignupList.
+func MakeSignupList() *SignupList {
+	return &SignupList{
+		byToken: make(map[string]pendingSignup),
+	}
+}
+
+// PendingSignups contains the logins waiting for an username.
+var PendingSignups = MakeSignupList()
+
+// isRegistrationEnabled returns whenever new users can sign up.
+func isRegistrationEnabled() bool {
+	return App.Conf.GetBoolean("oauth.enableRegistration")
+}
+
+// handleSignup creates an user with the username requested and
+// the login of the pending signup identified by the token.
+func handleSignup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !isRegistrationEnabled() {
+		writeAPIError(w, http.StatusForbidden, "Registration is disabled")
+		return
+	}
+
+	ip, err := getReqIP(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Cannot get IP address")
+		return
+	}
+
+	if !App.Limits.Allow("signup", ip) {
+		writeAPIError(w, http.StatusTooManyRequests, "You are signing up too fast, please try again later")
+		return
+	}
+
+	token := r.PostFormValue("token")
+	name := r.PostFormValue("username")
+
+	if err := ValidateUsername(name); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	login, ok := PendingSignups.Take(token)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "Invalid or expired signup token, please sign in again")
+		return
+	}
+
+	taken, err := App.DB.IsUsernameTaken(name)
+	if err != nil {
+		PendingSignups.Restore(token, login)
+		fmt.Fprintf(os.Stderr, "cannot check if username %s is taken: %v\n", name, err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot sign up")
+		return
+	}
+	if taken {
+		PendingSignups.Restore(token, login)
+		writeAPIError(w, http.StatusConflict, "Username is already taken")
+		return
+	}
+
+	dbUser, err := App.DB.CreateUser(name, login, ip, r.UserAgent())
+	if err != nil {
+		PendingSignups.Restore(token, login)
+		if IsDuplicateKeyError(err) {
+			// Note(netux): someone else signed up with the same username since we checked
+			writeAPIError(w, http.StatusConflict, "Username is already taken")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "cannot create user with login %s in database: %v\n", login.String(), err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot sign up")
+		return
+	}
+
+	if _, err := createSession(w, dbUser.ID); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot sign up")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -2,95 +2,225 @@ package main
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
 // PixelStacker increases the user's available pixels over time.
-// It uses the channel C to communicate that the Stack has changed:
-// - if it sends `true`, the Stack gained a pixel
-// - if it sends `false`, the Stack was consumed
+// It uses the channel C to communicate that the stack has changed:
+// - if it sends `true`, the stack gained a pixel
+// - if it sends `false`, the stack was consumed
+// It's safe to use from multiple goroutines.
 type PixelStacker struct {
+	mu          sync.Mutex
 	ctx         context.Context
 	ctxCancel   context.CancelFunc
-	CooldownEnd time.Time
-	Stack       uint
+	cooldownEnd time.Time
+	stack       uint
 	C           chan bool
+
+	// placement is the last placement of the user,
+	// which affects the cooldown until the next pixel gain.
+	placement Placement
+}
+
+// Stack returns the amount of pixels available.
+func (ps *PixelStacker) Stack() uint {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.stack
+}
+
+// CooldownEnd returns when the next pixel is gained.
+func (ps *PixelStacker) CooldownEnd() time.Time {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.cooldownEnd
+}
+
+// State returns both the amount of pixels available and when the next one is gained.
+func (ps *PixelStacker) State() (stack uint, cooldownEnd time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.stack, ps.cooldownEnd
+}
+
+// SetPlacement sets the last placement of the user.
+func (ps *PixelStacker) SetPlacement(p Placement) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.placement = p
 }
 
 func (ps *PixelStacker) getAndUpdateCooldown() (cd time.Duration) {
-	cd = ps.GetCooldown()
-	ps.CooldownEnd = time.Now().Add(cd)
+	cd = ps.getCooldown()
+	ps.cooldownEnd = time.Now().Add(cd)
 	return
 }
 
-func (ps *PixelStacker) run() {
-	var max = uint(App.Conf.GetInt32("stacking.maxStacked"))
-	cd := ps.getAndUpdateCooldown()
-
+// run gains pixels every cooldown, starting after cd, until ctx is done or max is passed.
+func (ps *PixelStacker) run(ctx context.Context, cd time.Duration, max uint) {
 	// Note(netux): <= instead of < is intentional
-	for ps.Stack <= max {
+	for ps.Stack() <= max {
 		select {
 		case <-time.After(cd):
-			ps.Gain()
-			cd = ps.getAndUpdateCooldown()
-		case <-ps.ctx.Done():
+		case <-ctx.Done():
 			return
 		}
+
+		ps.mu.Lock()
+		// Note(netux): the timer may have been stopped or restarted while waiting for the lock
+		if ctx.Err() != nil {
+			ps.mu.Unlock()
+			return
+		}
+		ps.gain()
+		ps.placement = Placement{}
+		cd = ps.getAndUpdateCooldown()
+		ps.mu.Unlock()
 	}
 }
 
 // GetCooldown returns the user's cooldown in between receiving
 // available pixels based on how many pixels they've got
-// available already, and a multiplicative factor.
+// available already, their last placement, and a multiplicative factor.
 func (ps *PixelStacker) GetCooldown() time.Duration {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.getCooldown()
+}
+
+func (ps *PixelStacker) getCooldown() time.Duration {
 	// TODO(netux): check if the second stacked pixel has twice the factor
 	var factor = float32(App.Conf.GetFloat32("stacking.cooldownMultiplier"))
-	return time.Duration(float32(ps.Stack+1)*factor) * App.GetCooldown()
+	return time.Duration(float32(ps.stack+1)*factor) * App.GetCooldown(ps.placement)
 }
 
 // GetCooldownWithDifference returns the user's cooldown that is left
 // since the last pixel gain.
 func (ps *PixelStacker) GetCooldownWithDifference() (cd time.Duration) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
 	var now = time.Now()
-	cd = ps.GetCooldown()
-	if now.Before(ps.CooldownEnd) {
-		cd -= cd - ps.CooldownEnd.Sub(now)
+	cd = ps.getCooldown()
+	if now.Before(ps.cooldownEnd) {
+		cd -= cd - ps.cooldownEnd.Sub(now)
 	}
 	return cd
 }
 
-// StartTimer starts the PixelStacker
+// StartTimer starts the PixelStacker with a full cooldown
 func (ps *PixelStacker) StartTimer() {
-	if ps.IsTimerRunning() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.startTimer(ps.getAndUpdateCooldown())
+}
+
+// ResumeTimer starts the PixelStacker, waiting only for the rest
+// of the cooldown if the cooldown end wasn't reached yet.
+// The pixels gained while it was stopped are added to the stack.
+func (ps *PixelStacker) ResumeTimer() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if !ps.cooldownEnd.IsZero() {
+		var cooldownEnd = ps.cooldownEnd
+		ps.restore(ps.stack, &cooldownEnd)
+	}
+	if left := time.Until(ps.cooldownEnd); left > 0 {
+		ps.startTimer(left)
+		return
+	}
+	ps.startTimer(ps.getAndUpdateCooldown())
+}
+
+func (ps *PixelStacker) startTimer(cd time.Duration) {
+	if ps.isTimerRunning() {
 		ps.ctxCancel()
 	}
 	ps.ctx, ps.ctxCancel = context.WithCancel(context.Background())
-	go ps.run()
+	go ps.run(ps.ctx, cd, uint(App.Conf.GetInt32("stacking.maxStacked")))
 }
 
 // StopTimer stops the PixelStacker
 func (ps *PixelStacker) StopTimer() {
-	ps.ctxCancel()
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.ctxCancel != nil {
+		ps.ctxCancel()
+	}
 }
 
 // IsTimerRunning returns whenever the pixel stacker's timer is running
 func (ps *PixelStacker) IsTimerRunning() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.isTimerRunning()
+}
+
+func (ps *PixelStacker) isTimerRunning() bool {
 	return ps.ctx != nil && ps.ctx.Err() != context.Canceled
 }
 
 // Gain increases the stack and notifies that through the channel C.
 func (ps *PixelStacker) Gain() {
-	if ps.Stack <= uint(App.Conf.GetInt32("stacking.maxStacked")) {
-		ps.Stack++
-		ps.C <- true
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.gain()
+}
+
+func (ps *PixelStacker) gain() {
+	if ps.stack <= uint(App.Conf.GetInt32("stacking.maxStacked")) {
+		ps.stack++
+		ps.notify(true)
 	}
 }
 
 // Consume decreases the stack and notifies that through the channel C.
-func (ps *PixelStacker) Consume() {
-	if ps.Stack > 0 {
-		ps.Stack--
-		ps.C <- false
+// It returns false if there was no pixel to consume.
+func (ps *PixelStacker) Consume() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.stack == 0 {
+		return false
+	}
+	ps.stack--
+	ps.notify(false)
+	return true
+}
+
+// notify sends the event through the channel C, unless an event is already waiting to be read.
+// Note(netux): readers only send the current stack, so the waiting event is enough.
+func (ps *PixelStacker) notify(isGain bool) {
+	select {
+	case ps.C <- isGain:
+	default:
+	}
+}
+
+// Restore sets the stack and cooldown end to the ones saved for the user, and
+// gains the pixels the user would have got since then, up to stacking.maxStacked.
+func (ps *PixelStacker) Restore(stack uint, cooldownEnd *time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.restore(stack, cooldownEnd)
+}
+
+func (ps *PixelStacker) restore(stack uint, cooldownEnd *time.Time) {
+	var max = uint(App.Conf.GetInt32("stacking.maxStacked"))
+
+	ps.stack = stack
+	if cooldownEnd == nil {
+		return
+	}
+
+	var now = time.Now()
+	ps.cooldownEnd = *cooldownEnd
+	// Note(netux): same condition as in run
+	for ps.stack <= max && !ps.cooldownEnd.After(now) {
+		ps.stack++
+		ps.cooldownEnd = ps.cooldownEnd.Add(ps.getCooldown())
 	}
 }
 
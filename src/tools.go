@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Canvas tool actions, as sent in the action form value of /admin/fill
+const (
+	// FillToolAction sets every pixel of the area to a palette color
+	FillToolAction = "fill"
+	// ClearToolAction sets every pixel of the area to the default color
+	ClearToolAction = "clear"
+	// RestoreToolAction sets every pixel of the area back to the last color placed by a player
+	RestoreToolAction = "restore"
+)
+
+// DefaultToolsMaxArea is the default largest area, in pixels, the canvas tools can act on at once
+const DefaultToolsMaxArea = 250000
+
+// getToolsMaxArea returns the largest area, in pixels, the canvas tools can act on at once.
+func getToolsMaxArea() uint {
+	return uint(App.Conf.GetInt64("board.toolsMaxArea", DefaultToolsMaxArea))
+}
+
+type apiFillResult struct {
+	Changed int  `json:"changed"`
+	DryRun  bool `json:"dryRun"`
+}
+
+// toolArea is a rectangle of the canvas, optionally masked.
+type toolArea struct {
+	X, Y, Width, Height uint
+	// Mask holds whenever each pixel of the rectangle, from left to right
+	// and top to bottom, is part of the area, or is nil if all are.
+	Mask []bool
+}
+
+// Contains returns whenever the pixel at the given canvas position is part of the area.
+func (a *toolArea) Contains(x, y uint) bool {
+	if x < a.X || y < a.Y || x >= a.X+a.Width || y >= a.Y+a.Height {
+		return false
+	}
+	return a.Mask == nil || a.Mask[(x-a.X)+(y-a.Y)*a.Width]
+}
+
+// getReqToolArea parses the x, y, width, height and mask form values of the request.
+// The mask, if set, is a string of width*height 0s and 1s.
+func getReqToolArea(r *http.Request) (*toolArea, error) {
+	var v [4]uint64
+	for i, key := range []string{"x", "y", "width", "height"} {
+		n, err := strconv.ParseUint(r.PostFormValue(key), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s", key)
+		}
+		v[i] = n
+	}
+
+	a := &toolArea{uint(v[0]), uint(v[1]), uint(v[2]), uint(v[3]), nil}
+	if a.Width == 0 || a.Height == 0 || a.X+a.Width > App.Canvas.Width || a.Y+a.Height > App.Canvas.Height {
+		return nil, fmt.Errorf("area is empty or outside of the canvas")
+	}
+	if max := getToolsMaxArea(); a.Width*a.Height > max {
+		return nil, fmt.Errorf("area is larger than %d pixels", max)
+	}
+
+	if mask := r.PostFormValue("mask"); mask != "" {
+		if uint(len(mask)) != a.Width*a.Height {
+			return nil, fmt.Errorf("mask size doesn't match the area")
+		}
+		a.Mask = make([]bool, len(mask))
+		for i, c := range mask {
+			switch c {
+			case '0':
+			case '1':
+				a.Mask[i] = true
+			default:
+				return nil, fmt.Errorf("mask can only contain 0s and 1s")
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// getToolPixels returns the pixels of the area that would change color after the action.
+func getToolPixels(a *toolArea, action string, color byte) ([]*DBPixel, error) {
+	var defaultColor = byte(App.Conf.GetInt32("board.defaultColor"))
+
+	target := make(map[[2]uint]byte)
+	for y := a.Y; y < a.Y+a.Height; y++ {
+		for x := a.X; x < a.X+a.Width; x++ {
+			if !a.Contains(x, y) {
+				continue
+			}
+			switch action {
+			case FillToolAction:
+				target[[2]uint{x, y}] = color
+			default:
+				target[[2]uint{x, y}] = defaultColor
+			}
+		}
+	}
+
+	if action == RestoreToolAction {
+		err := App.DB.EachLastPlayerPixelIn(a.X, a.Y, a.Width, a.Height, func(p *DBPixel) {
+			if a.Contains(p.PosX, p.PosY) {
+				target[[2]uint{p.PosX, p.PosY}] = p.ColorIdx
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var pixels []*DBPixel
+	for y := a.Y; y < a.Y+a.Height; y++ {
+		for x := a.X; x < a.X+a.Width; x++ {
+			c, ok := target[[2]uint{x, y}]
+			if ok && App.Canvas.GetPixelColorIndex(x, y) != c {
+				pixels = append(pixels, &DBPixel{PosX: x, PosY: y, ColorIdx: c})
+			}
+		}
+	}
+	return pixels, nil
+}
+
+// handleAdminFill fills an area of the canvas with the color form value, the default color,
+// or the last colors placed by players, depending on the action form value.
+// If the dryrun form value is true, nothing is changed.
+// Responds with the amount of pixels that changed, or would change.
+func handleAdminFill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	mod, ok := requirePermission(w, r, CanvasToolsPermission)
+	if !ok {
+		return
+	}
+
+	area, err := getReqToolArea(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var color byte
+	action := r.PostFormValue("action")
+	switch action {
+	case FillToolAction:
+		c, err := strconv.ParseUint(r.PostFormValue("color"), 10, 8)
+		if err != nil || int(c) >= len(App.Palette) {
+			writeAPIError(w, http.StatusBadRequest, "Invalid color")
+			return
+		}
+		color = byte(c)
+	case ClearToolAction, RestoreToolAction:
+	default:
+		writeAPIError(w, http.StatusBadRequest, "Invalid action")
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.PostFormValue("dryrun"))
+
+	pixels, err := getToolPixels(area, action, color)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot fetch pixels of area from database: %v\n", err)
+		writeAPIError(w, http.StatusInternalServerError, "Cannot fill area")
+		return
+	}
+
+	if !dryRun {
+		if err := App.DB.PlaceModPixels(pixels, mod); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot place pixels by user with ID %d in database: %v\n", mod.ID, err)
+			writeAPIError(w, http.StatusInternalServerError, "Cannot fill area")
+			return
+		}
+		applyPixels(pixels)
+	}
+
+	w.Header().Set("Content-Type", "text/json")
+	json.NewEncoder(w).Encode(apiFillResult{len(pixels), dryRun})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// adminFill calls /admin/fill as the given user with the form values.
+func adminFill(u *User, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/admin/fill", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Note(netux): test users are cached with their name as session token
+	req.AddCookie(&http.Cookie{Name: "pxls-token", Value: u.Name})
+
+	rec := httptest.NewRecorder()
+	handleAdminFill(rec, req)
+	return rec
+}
+
+// setupToolsTest creates an user granted CanvasToolsPermission.
+func setupToolsTest(t *testing.T, extraConf string) *User {
+	setupTestApp(t, extraConf)
+	dev := newTestUser(t, "dev")
+	setTestRole(t, dev, DeveloperUserRole)
+	return dev
+}
+
+// countModPixels returns how many pixels placed by the moderator are most recent and have the color.
+func countModPixels(t *testing.T, mod *User, color byte) (n int) {
+	err := App.DB.sql.Model(&DBPixel{}).
+		Where("who = ? AND mod_action AND most_recent AND color = ?", mod.ID, color).
+		Count(&n).Error
+	if err != nil {
+		t.Fatalf("cannot count pixels: %v", err)
+	}
+	return
+}
+
+func TestAdminFillAreaLimit(t *testing.T) {
+	dev := setupToolsTest(t, "board { toolsMaxArea: 16 }")
+
+	rec := adminFill(dev, url.Values{
+		"action": {FillToolAction}, "color": {"2"},
+		"x": {"0"}, "y": {"0"}, "width": {"5"}, "height": {"4"},
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("filling an area larger than the limit responded with status %d, expected %d", rec.Code, http.StatusBadRequest)
+	}
+	if c := App.Canvas.GetPixelColorIndex(0, 0); c != 0 {
+		t.Errorf("pixel has color %d after a rejected fill, expected 0", c)
+	}
+	if n := countModPixels(t, dev, 2); n != 0 {
+		t.Errorf("%d pixels were saved after a rejected fill", n)
+	}
+
+	rec = adminFill(dev, url.Values{
+		"action": {FillToolAction}, "color": {"2"},
+		"x": {"0"}, "y": {"0"}, "width": {"4"}, "height": {"4"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Errorf("filling an area as large as the limit responded with status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAdminFillAndClear(t *testing.T) {
+	dev := setupToolsTest(t, "")
+
+	// Note(netux): the whole canvas, so the pixels are inserted in more than one query
+	rec := adminFill(dev, url.Values{
+		"action": {FillToolAction}, "color": {"2"},
+		"x": {"0"}, "y": {"0"}, "width": {"16"}, "height": {"16"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("fill responded with status %d: %s", rec.Code, rec.Body)
+	}
+	var res apiFillResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("cannot decode fill response: %v", err)
+	}
+	if res.Changed != 256 {
+		t.Errorf("fill changed %d pixels, expected 256", res.Changed)
+	}
+	if c := App.Canvas.GetPixelColorIndex(15, 15); c != 2 {
+		t.Errorf("pixel has color %d after filling, expected 2", c)
+	}
+	if n := countModPixels(t, dev, 2); n != 256 {
+		t.Errorf("%d filled pixels are saved as most recent, expected 256", n)
+	}
+
+	rec = adminFill(dev, url.Values{
+		"action": {ClearToolAction},
+		"x":      {"2"}, "y": {"3"}, "width": {"4"}, "height": {"2"}, "mask": {"11110110"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("clear responded with status %d: %s", rec.Code, rec.Body)
+	}
+	if c := App.Canvas.GetPixelColorIndex(2, 3); c != 0 {
+		t.Errorf("cleared pixel has color %d, expected 0", c)
+	}
+	if c := App.Canvas.GetPixelColorIndex(2, 4); c != 2 {
+		t.Errorf("pixel outside of the mask has color %d after clearing, expected 2", c)
+	}
+	if n := countModPixels(t, dev, 0); n != 6 {
+		t.Errorf("%d cleared pixels are saved as most recent, expected 6", n)
+	}
+	if n := countModPixels(t, dev, 2); n != 250 {
+		t.Errorf("%d filled pixels are still the most recent after clearing, expected 250", n)
+	}
+
+	cleared, err := App.DB.GetPixelAt(2, 3)
+	if err != nil {
+		t.Fatalf("cannot fetch cleared pixel: %v", err)
+	}
+	if cleared.SecondaryID == nil {
+		t.Fatal("cleared pixel doesn't reference the filled pixel")
+	}
+	var filled DBPixel
+	if err := App.DB.sql.First(&filled, "id = ?", *cleared.SecondaryID).Error; err != nil {
+		t.Fatalf("cannot fetch filled pixel: %v", err)
+	}
+	if filled.PosX != 2 || filled.PosY != 3 || filled.ColorIdx != 2 || filled.IsMostRecent {
+		t.Errorf("cleared pixel references %+v, expected the pixel filled at (2, 3)", filled)
+	}
+}
@@ -2,16 +2,10 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
-)
-
-// UserRole is the role of an user
-type UserRole string
-
-const (
-	// DefaultUserRole is the role an user which can only
-	// place pixels, lookup pixels, report other users, and chat
-	DefaultUserRole = "USER"
+	"sync"
+	"time"
 )
 
 // UserLogin holds user login information.
@@ -39,51 +33,197 @@ func ParseUserLogin(login string) UserLogin {
 type User struct {
 	*DBUser
 	PixelStacker *PixelStacker
+
+	// lastPixel is the last pixel placed by the user,
+	// and lastPixelEnd is when it stops being undoable.
+	// Both are guarded by lastPixelMu, as every connection of the user places.
+	lastPixelMu  sync.Mutex
+	lastPixel    *DBPixel
+	lastPixelEnd time.Time
 }
 
-// MakeUser creates an User with the given DBUser
+// MakeUser creates an User with the given DBUser,
+// restoring the pixels they had stacked.
 func MakeUser(dbUser *DBUser) *User {
 	u := &User{
-		dbUser,
-		MakePixelStacker(),
+		DBUser:       dbUser,
+		PixelStacker: MakePixelStacker(),
+	}
+	var stack uint
+	if dbUser.Stacked > 0 {
+		stack = uint(dbUser.Stacked)
 	}
+	u.PixelStacker.Restore(stack, dbUser.CooldownExpiry)
 	return u
 }
 
+// SaveStacker saves the user's stacked pixels and cooldown expiry in the database.
+func (u *User) SaveStacker() error {
+	stack, cooldownEnd := u.PixelStacker.State()
+	if cooldownEnd.IsZero() {
+		// Note(netux): the timer never ran, so there's no cooldown to save
+		return App.DB.SetUserStackedPixels(u.ID, stack)
+	}
+	return App.DB.SetUserStacker(u.ID, stack, cooldownEnd)
+}
+
+// SetUndoablePixel sets the pixel the user can undo until the window has passed.
+func (u *User) SetUndoablePixel(pixel *DBPixel, window time.Duration) {
+	u.lastPixelMu.Lock()
+	defer u.lastPixelMu.Unlock()
+	u.lastPixel = pixel
+	u.lastPixelEnd = time.Now().Add(window)
+}
+
+// TakeUndoablePixel returns the pixel the user can undo, if any,
+// and clears it so it can't be undone twice.
+func (u *User) TakeUndoablePixel() (pixel *DBPixel, ok bool) {
+	u.lastPixelMu.Lock()
+	defer u.lastPixelMu.Unlock()
+	pixel = u.lastPixel
+	ok = pixel != nil && time.Now().Before(u.lastPixelEnd)
+	u.lastPixel = nil
+	return
+}
+
 // UserList contains cached users stored by different criteria.
+// It's safe to use from multiple goroutines.
 type UserList struct {
+	mu          sync.Mutex
 	byID        map[uint]*User
 	byTokenOrIP map[string]*User
 }
 
 // GetByID returns a cached user searched by it's ID.
 func (l *UserList) GetByID(id uint) (u *User, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	u, ok = l.byID[id]
 	return
 }
 
 // GetByTokenOrIP returns a cached user searched by its session token or IP.
 func (l *UserList) GetByTokenOrIP(tokenOrIP string) (u *User, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	u, ok = l.byTokenOrIP[tokenOrIP]
 	return
 }
 
+// Snapshot returns a copy of the fields of the user, taken with the list locked
+// so changes made through Update aren't seen halfway.
+func (l *UserList) Snapshot(u *DBUser) DBUser {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return *u
+}
+
+// Update changes the fields of the user through update, with the list locked.
+// Note(netux): cached users are shared by every request and connection of theirs,
+// so their fields are only changed through here and read through Snapshot.
+func (l *UserList) Update(u *DBUser, update func(u *DBUser)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	update(u)
+}
+
 // MakeAndAdd creates an *User with the given DBUser, adds it
 // to the user list and returns the *User.
+// If the user is already cached with another session token or IP,
+// the cached *User is added with the given one too.
+// If the user is already cached with the given session token or IP,
+// which happens when it was added by another request in the meantime, the cached *User is returned.
 func (l *UserList) MakeAndAdd(dbUser *DBUser, tokenOrIP string) (*User, error) {
-	u := MakeUser(dbUser)
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	_, idOk := l.byID[u.ID]
-	_, tokenOk := l.byTokenOrIP[tokenOrIP]
-	if idOk || tokenOk {
+	if u, tokenOk := l.byTokenOrIP[tokenOrIP]; tokenOk {
+		if u.ID == dbUser.ID {
+			return u, nil
+		}
 		return nil, fmt.Errorf("cannot add user already in user list")
 	}
 
-	l.byID[u.ID] = u
+	u, idOk := l.byID[dbUser.ID]
+	if !idOk {
+		u = MakeUser(dbUser)
+		l.byID[u.ID] = u
+	}
+
 	l.byTokenOrIP[tokenOrIP] = u
 	return u, nil
 }
 
+// RemoveByTokenOrIP removes the user cached with the given session token or IP.
+// The user is removed completely if it isn't cached with any other token or IP.
+func (l *UserList) RemoveByTokenOrIP(tokenOrIP string) {
+	l.mu.Lock()
+	u, ok := l.byTokenOrIP[tokenOrIP]
+	if !ok {
+		l.mu.Unlock()
+		return
+	}
+	delete(l.byTokenOrIP, tokenOrIP)
+
+	for _, other := range l.byTokenOrIP {
+		if other == u {
+			l.mu.Unlock()
+			return
+		}
+	}
+	delete(l.byID, u.ID)
+	l.mu.Unlock()
+
+	saveRemovedUser(u)
+}
+
+// RemoveByID removes the user with the given ID and every session token or IP it's cached with.
+func (l *UserList) RemoveByID(id uint) {
+	l.mu.Lock()
+	u, ok := l.byID[id]
+	if !ok {
+		l.mu.Unlock()
+		return
+	}
+
+	for tokenOrIP, other := range l.byTokenOrIP {
+		if other == u {
+			delete(l.byTokenOrIP, tokenOrIP)
+		}
+	}
+	delete(l.byID, u.ID)
+	l.mu.Unlock()
+
+	saveRemovedUser(u)
+}
+
+// saveRemovedUser stops the timer of a user removed from the list and saves its stacked pixels.
+// Note(netux): this is called without holding the list's lock, as it talks to the database.
+func saveRemovedUser(u *User) {
+	if u.PixelStacker.IsTimerRunning() {
+		u.PixelStacker.StopTimer()
+	}
+	if err := u.SaveStacker(); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot save stacked pixels for user with ID %d: %v\n", u.ID, err)
+	}
+}
+
+// SaveStackers saves the stacked pixels and cooldown expiry of every cached user in the database.
+func (l *UserList) SaveStackers() {
+	l.mu.Lock()
+	users := make([]*User, 0, len(l.byID))
+	for _, u := range l.byID {
+		users = append(users, u)
+	}
+	l.mu.Unlock()
+
+	for _, u := range users {
+		if err := u.SaveStacker(); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot save stacked pixels for user with ID %d: %v\n", u.ID, err)
+		}
+	}
+}
+
 // MakeUserList creates a new UserList.
 func MakeUserList() *UserList {
 	return &UserList{
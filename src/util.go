@@ -2,6 +2,10 @@ package main
 
 import (
 	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	"github.com/jinzhu/gorm"
 )
@@ -45,6 +49,49 @@ func GenerateToken(length uint) (string, error) {
 	return string(r), nil
 }
 
+// writeFileAtomic writes a file by calling write with a temporary file in the same directory,
+// syncing it to disk, and renaming it over path, so path is never left half written.
+// The directory is synced too, so the rename isn't lost on a crash.
+func writeFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err = write(f); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir syncs the directory to disk, persisting the entries created or renamed in it.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 // TODO(netux): these are unused for now, uncomment when needed
 // RemoveAtIndexFromUnorderedArray removes the element at
 // index i of the array by replacing it with the last element
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+const (
+	// VirginmapFile is the name of the virginmap file
+	VirginmapFile = "virginmap.dat"
+
+	virginPixel  byte = 0xFF
+	touchedPixel byte = 0x00
+)
+
+// Virginmap keeps track of which pixels of the canvas were never placed on.
+// It's safe to use from multiple goroutines.
+type Virginmap struct {
+	Width  uint
+	Height uint
+	// Board holds 0xFF for every pixel that was never placed on, and 0x00 otherwise.
+	Board []byte
+
+	mu sync.RWMutex
+}
+
+// NewVirginmap creates a new virginmap where every pixel is untouched.
+func NewVirginmap(w, h uint) *Virginmap {
+	vm := &Virginmap{Width: w, Height: h, Board: make([]byte, w*h)}
+	for i := range vm.Board {
+		vm.Board[i] = virginPixel
+	}
+	return vm
+}
+
+// Clear marks the pixel as placed on.
+func (vm *Virginmap) Clear(x, y uint) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	vm.Board[x+y*vm.Width] = touchedPixel
+}
+
+// IsVirgin returns whenever the pixel was never placed on.
+func (vm *Virginmap) IsVirgin(x, y uint) bool {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+	return vm.Board[x+y*vm.Width] == virginPixel
+}
+
+// Snapshot returns a copy of the board, taken while no pixel is being cleared.
+func (vm *Virginmap) Snapshot() []byte {
+	vm.mu.RLock()
+	defer vm.mu.RUnlock()
+	board := make([]byte, len(vm.Board))
+	copy(board, vm.Board)
+	return board
+}
+
+// populateVirginmapFromFile reads the virginmap file and writes its contents to the virginmap.
+// If the file doesn't exist or doesn't fit the canvas, the virginmap is rebuilt from the database.
+func populateVirginmapFromFile(vm *Virginmap, path string, db *Database) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil && uint(len(b)) == vm.Width*vm.Height {
+		vm.mu.Lock()
+		vm.Board = b
+		vm.mu.Unlock()
+		return nil
+	}
+
+	if err == nil {
+		fmt.Printf("saved %s size and canvas configuration differ, rebuilding it from the database\n", path)
+	} else {
+		fmt.Printf("%s not found, rebuilding it from the database\n", path)
+	}
+	return populateVirginmapFromDatabase(vm, db)
+}
+
+// populateVirginmapFromDatabase clears every pixel that was ever placed on.
+func populateVirginmapFromDatabase(vm *Virginmap, db *Database) error {
+	return db.EachPlacedPosition(func(x, y uint) {
+		if x < vm.Width && y < vm.Height {
+			vm.Clear(x, y)
+		}
+	})
+}
+
+// saveVirginmap writes a snapshot of the virginmap into the virginmap file.
+func saveVirginmap(vm *Virginmap, path string) error {
+	board := vm.Snapshot()
+	return writeFileAtomic(path, 0644, func(w io.Writer) error {
+		_, err := w.Write(board)
+		return err
+	})
+}
@@ -7,23 +7,107 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-// TODO(netux): implement something similar to this
-// https://github.com/gorilla/websocket/tree/master/examples/chat
+// Note(netux): based on https://github.com/gorilla/websocket/tree/master/examples/chat
+const (
+	// SendQueueSize is how many messages can be waiting to be sent to a client
+	// before it's considered too slow and disconnected
+	SendQueueSize = 256
+	// WriteTimeout is how long sending a message to a client can take
+	// before it's considered gone and disconnected
+	WriteTimeout = 10 * time.Second
+)
 
 type wsConn struct {
 	*websocket.Conn
 	ctx       context.Context
+	cancel    context.CancelFunc
 	user      *User
+	token     string
+	ip        string
 	sendQueue chan interface{}
+
+	// pendingPixel is the pixel waiting for a captcha to be solved before being placed.
+	pendingPixel *wsPixelReq
+	// cooldownOverride is 1 whenever the staff member using this connection
+	// can place without consuming pixels or waiting for cooldown.
+	// Note(netux): it's read by other goroutines sending user info, use hasCooldownOverride.
+	cooldownOverride int32
+}
+
+// hasCooldownOverride returns whenever cooldown is overridden for the connection.
+func (conn *wsConn) hasCooldownOverride() bool {
+	return atomic.LoadInt32(&conn.cooldownOverride) == 1
+}
+
+// setCooldownOverride sets whenever cooldown is overridden for the connection.
+func (conn *wsConn) setCooldownOverride(override bool) {
+	var v int32
+	if override {
+		v = 1
+	}
+	atomic.StoreInt32(&conn.cooldownOverride, v)
+}
+
+// sessionKey returns what identifies the owner of the connection
+// when counting sessions: the user's ID, or the IP if there's no user.
+func (conn *wsConn) sessionKey() string {
+	if conn.user != nil {
+		return fmt.Sprintf("user:%d", conn.user.ID)
+	}
+	return "ip:" + conn.ip
 }
 
+// queue queues the message to be sent to the client without waiting,
+// disconnecting the client if too many messages are waiting already.
 func (conn *wsConn) queue(msg interface{}) {
-	conn.sendQueue <- msg
+	select {
+	case <-conn.ctx.Done():
+		return
+	default:
+	}
+
+	select {
+	case conn.sendQueue <- msg:
+	default:
+		// Note(netux): no close message is sent, it would have to wait for the client too
+		conn.cancel()
+		conn.Close()
+	}
+}
+
+// writeMessages sends the queued messages to the client until the connection is closed.
+// The connection is closed if sending a message fails or takes longer than WriteTimeout.
+func (conn *wsConn) writeMessages() {
+	for {
+		select {
+		case msg := <-conn.sendQueue:
+			conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+			if err := conn.WriteJSON(msg); err != nil {
+				conn.cancel()
+				conn.Close()
+				return
+			}
+		case <-conn.ctx.Done():
+			return
+		}
+	}
+}
+
+// close sends a close message with the given reason and closes the connection.
+func (conn *wsConn) close(code int, reason string) {
+	conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second),
+	)
+	conn.cancel()
+	conn.Close()
 }
 
 type wsMessageType string
@@ -40,8 +124,25 @@ var wsUpgrader = websocket.Upgrader{
 	WriteBufferSize: MaxWebsocketSendBufferSize,
 }
 
-// Connections is an array of all active websocket connections.
-var Connections = make(map[string]*wsConn)
+// Connections is the hub of all active websocket connections.
+var Connections = NewHub()
+
+// DefaultSessionLimit is the default maximum amount of simultaneous
+// websocket connections an user (or IP, if not logged in) can have open.
+const DefaultSessionLimit = 3
+
+// getSessionLimit returns the maximum amount of simultaneous websocket
+// connections an user (or IP, if not logged in) can have open.
+func getSessionLimit() int {
+	return int(App.Conf.GetInt32("server.sessionLimit", DefaultSessionLimit))
+}
+
+// connectionsOfUser returns every open connection of the user with the given ID.
+func connectionsOfUser(uid uint) []*wsConn {
+	return Connections.Filter(func(conn *wsConn) bool {
+		return conn.user != nil && conn.user.ID == uid
+	})
+}
 
 func getReqIP(r *http.Request) (string, error) {
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
@@ -60,8 +161,16 @@ func getReqPxlsToken(r *http.Request) (string, error) {
 	return pxlsTokenCookie.Value, nil
 }
 
+// IPUsernameTokenLength is the length of the token after IPUsernamePrefix in the username of users signed in by IP
+const IPUsernameTokenLength = 16
+
 func newUserByIP(ip, ua string) (*User, error) {
-	dbUser, err := App.DB.CreateUser("-snip-", UserLogin{"ip", ip}, ip, ua)
+	suffix, err := GenerateToken(IPUsernameTokenLength)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate username for user with IP %s: %v", ip, err)
+	}
+
+	dbUser, err := App.DB.CreateUser(IPUsernamePrefix+suffix, UserLogin{"ip", ip}, ip, ua)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create user in database with IP %s: %v", ip, err)
 	}
@@ -151,11 +260,24 @@ func upgradeSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
 		return nil, err
 	}
 
+	// Note(netux): the token is kept so the connection can be closed on logout
+	token, _ := getReqPxlsToken(r)
+
+	ip, err := getReqIP(r)
+	if err != nil {
+		return nil, err
+	}
+
 	return &wsConn{
 		conn,
 		ctx,
+		cancel,
 		user,
-		make(chan interface{}),
+		token,
+		ip,
+		make(chan interface{}, SendQueueSize),
+		nil,
+		0,
 	}, nil
 }
 
@@ -168,42 +290,38 @@ func HandleWebsocketPath(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ip, err := getReqIP(r)
-	if err != nil {
+	if err := Connections.Register(conn, getSessionLimit()); err != nil {
+		if err == ErrHubClosed {
+			conn.close(websocket.CloseGoingAway, "server shutting down")
+			return
+		}
+		// Note(netux): the send queue isn't being handled yet, so write directly
+		conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+		conn.WriteJSON(withType(wsSessionLimitType))
+		conn.close(websocket.ClosePolicyViolation, "too many sessions")
 		return
 	}
 
-	Connections[ip] = conn
-	go func() {
-		for {
-			select {
-			case m := <-conn.sendQueue:
-				conn.WriteJSON(m)
-			case <-conn.ctx.Done():
-				return
-			}
-		}
-	}()
+	scheduleUsersBroadcast()
+	go conn.writeMessages()
 
+	sendUsers(conn)
+	sendActiveAnnouncements(conn)
 	if conn.user != nil {
 		// Note(netux): Needed so the max stacked on the client updates
 		sendPixelsAvailable(conn, "auth")
 		sendUserInfo(conn)
-		if conn.user.PixelStacker.Stack > 0 {
+		if conn.user.PixelStacker.Stack() > 0 {
 			sendPixelsAvailable(conn, "connected")
-		}
-		if conn.user.PixelStacker.Stack == 0 {
+		} else {
 			sendCooldown(conn, conn.user.PixelStacker.GetCooldownWithDifference())
 		}
 		if !conn.user.PixelStacker.IsTimerRunning() {
-			conn.user.PixelStacker.StartTimer()
+			conn.user.PixelStacker.ResumeTimer()
 		}
 	}
 
 	go handleIncomingMessages(conn)
-	if conn.user != nil {
-		go handleUserEvents(conn)
-	}
 }
 
 func handleUserEvents(conn *wsConn) {
@@ -214,8 +332,12 @@ func handleUserEvents(conn *wsConn) {
 			if isGain {
 				cause = "stackGain"
 			}
-			sendPixelsAvailable(conn, cause)
-			if err := App.DB.SetUserStackedPixels(conn.user.ID, conn.user.PixelStacker.Stack); err != nil {
+			// Note(netux): every connection of the user reads from the same channel,
+			// so whichever gets the event notifies all of them.
+			for _, userConn := range connectionsOfUser(conn.user.ID) {
+				sendPixelsAvailable(userConn, cause)
+			}
+			if err := App.DB.SetUserStackedPixels(conn.user.ID, conn.user.PixelStacker.Stack()); err != nil {
 				fmt.Fprintf(os.Stderr, "cannot save stacked pixels for user with ID %d: %v", conn.user.ID, err)
 			}
 		case <-conn.ctx.Done():
@@ -224,15 +346,45 @@ func handleUserEvents(conn *wsConn) {
 	}
 }
 
+// handleIncomingMessages handles the messages of the connection, and the events
+// of its user, until it's closed, after which it's marked as done in Connections.
 func handleIncomingMessages(conn *wsConn) {
+	var eventsDone = make(chan struct{})
+	if conn.user != nil {
+		go func() {
+			defer close(eventsDone)
+			handleUserEvents(conn)
+		}()
+	} else {
+		close(eventsDone)
+	}
+
 	defer func() {
-		delete(Connections, conn.RemoteAddr().String())
+		Connections.Unregister(conn)
+		if conn.user != nil && len(connectionsOfUser(conn.user.ID)) == 0 {
+			// Note(netux): no one is left to read the stacker's events, it's resumed on the next connection
+			if conn.user.PixelStacker.IsTimerRunning() {
+				conn.user.PixelStacker.StopTimer()
+			}
+			if err := conn.user.SaveStacker(); err != nil {
+				fmt.Fprintf(os.Stderr, "cannot save stacked pixels for user with ID %d: %v\n", conn.user.ID, err)
+			}
+		}
+		scheduleUsersBroadcast()
+		conn.cancel()
 		conn.Close()
+
+		<-eventsDone
+		Connections.Done()
 	}()
 
 	for {
 		_, rawMsg, err := conn.ReadMessage()
 		if err != nil {
+			if conn.ctx.Err() != nil {
+				// Note(netux): the connection was closed by us
+				return
+			}
 			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNoStatusReceived) {
 				return
 			}
@@ -261,6 +413,43 @@ func handleIncomingMessages(conn *wsConn) {
 				break
 			}
 			handlePixel(conn, pixelMsg)
+		case wsCaptchaType:
+			if conn.user == nil {
+				break
+			}
+
+			var captchaMsg wsCaptchaReq
+			if err := json.Unmarshal(rawMsg, &captchaMsg); err != nil {
+				fmt.Fprintf(os.Stderr, "websocket JSON Captcha parsing error: %v\n", err)
+				break
+			}
+			handleCaptcha(conn, captchaMsg)
+		case wsUndoType:
+			if conn.user == nil {
+				break
+			}
+
+			handleUndo(conn)
+		case wsChatMessageReqType:
+			handleChatMessage(conn, rawMsg)
+		case wsChatHistoryReqType:
+			handleChatHistory(conn)
+		case wsChatbanStateReqType:
+			sendChatbanState(conn)
+		case wsCooldownOverrideType:
+			var overrideMsg wsCooldownOverrideReq
+			if err := json.Unmarshal(rawMsg, &overrideMsg); err != nil {
+				fmt.Fprintf(os.Stderr, "websocket JSON Cooldown Override parsing error: %v\n", err)
+				break
+			}
+			handleCooldownOverride(conn, overrideMsg)
+		case wsAdminMessageType:
+			var adminMsg wsAdminMessageReq
+			if err := json.Unmarshal(rawMsg, &adminMsg); err != nil {
+				fmt.Fprintf(os.Stderr, "websocket JSON Admin Message parsing error: %v\n", err)
+				break
+			}
+			handleAdminMessage(conn, adminMsg)
 		default:
 			fmt.Fprintf(os.Stderr, "unhandled websocket msgType %s: %v\n", msgType, string(rawMsg))
 		}
@@ -278,28 +467,58 @@ type wsUserInfo struct {
 	CooldownOverride bool `json:"cdOverride"`
 
 	IsBanned           bool   `json:"banned"`
-	BanExpiry          int    `json:"banExpiry"`
+	BanExpiry          int64  `json:"banExpiry"`
 	BanReason          string `json:"ban_reason"`
 	IsChatBanned       bool   `json:"chatBanned"`
-	ChatBanExpiry      int    `json:"chatBanExpiry"`
+	ChatBanExpiry      int64  `json:"chatBanExpiry"`
 	ChatBanIsPermanent bool   `json:"chatBanIsPerma"`
 }
 
+// toMillis returns the time in milliseconds since the epoch, or 0 if it's nil.
+func toMillis(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
 // sendUserInfo sends an "userinfo" message through the websocket connection conn
 func sendUserInfo(conn *wsConn) {
-	conn.queue(wsUserInfo{
+	u := App.Users.Snapshot(conn.user.DBUser)
+	info := wsUserInfo{
 		wsMessage:  withType(wsUserInfoType),
-		AuthMethod: conn.user.Login.Method,
-		Role:       conn.user.Role,
-		Username:   conn.user.Name,
-	})
+		AuthMethod: u.Login.Method,
+		Role:       u.Role,
+		Username:   u.Name,
+
+		CooldownOverride: conn.hasCooldownOverride(),
+
+		IsBanned:           u.IsBanned(),
+		BanExpiry:          toMillis(u.BanExpiry),
+		BanReason:          u.BanReason,
+		IsChatBanned:       u.IsChatBanned(),
+		ChatBanExpiry:      toMillis(u.ChatBanExpiry),
+		ChatBanIsPermanent: u.IsPermanentlyChatBanned,
+	}
+
+	// Note(netux): shadowbanned users mustn't find out, so they are told they're regular users,
+	// only banned if they are temporarily banned too
+	if u.IsShadowbanned() {
+		info.Role = DefaultUserRole
+		if !info.IsBanned {
+			info.BanExpiry = 0
+			info.BanReason = ""
+		}
+	}
+
+	conn.queue(info)
 }
 
 const wsAckType = "ACK"
 
 type wsAck struct {
 	wsMessage
-	ackFor string
+	AckFor string `json:"ackFor"`
 }
 
 func ackFor(a string) wsAck {
@@ -317,7 +536,7 @@ type wsPixelsAvailable struct {
 func sendPixelsAvailable(conn *wsConn, cause string) {
 	conn.queue(wsPixelsAvailable{
 		withType(wsPixelsAvailableType),
-		conn.user.PixelStacker.Stack,
+		conn.user.PixelStacker.Stack(),
 		cause,
 	})
 }
@@ -360,49 +579,245 @@ type wsAckForPixel struct {
 	PosY uint `json:"y"`
 }
 
-func handlePixel(conn *wsConn, pixelMsg wsPixelReq) {
-	if conn.user == nil {
-		return
+// canPlacePixel returns whenever the pixel is inside the canvas, uses a color of
+// the palette, would change the canvas, and the user has pixels available.
+func canPlacePixel(conn *wsConn, pixelMsg wsPixelReq) bool {
+	if conn.user == nil || isShuttingDown() {
+		return false
 	}
 
-	var ps = conn.user.PixelStacker
-	if ps.Stack == 0 {
+	if pixelMsg.PosX >= App.Canvas.Width || pixelMsg.PosY >= App.Canvas.Height || int(pixelMsg.ColorIdx) >= len(App.Palette) {
+		return false
+	}
+
+	if state := App.Users.Snapshot(conn.user.DBUser); state.IsBanned() || (conn.user.PixelStacker.Stack() == 0 && !conn.hasCooldownOverride()) {
+		return false
+	}
+
+	return App.Canvas.GetPixelColorIndex(pixelMsg.PosX, pixelMsg.PosY) != pixelMsg.ColorIdx
+}
+
+func handlePixel(conn *wsConn, pixelMsg wsPixelReq) {
+	if !canPlacePixel(conn, pixelMsg) {
 		return
 	}
 
-	if App.Canvas.GetPixelColorIndex(pixelMsg.PosX, pixelMsg.PosY) == pixelMsg.ColorIdx {
+	if needsCaptcha(conn.user) {
+		// Note(netux): the pixel is placed once the captcha is verified
+		conn.pendingPixel = &pixelMsg
+		conn.queue(withType(wsCaptchaRequiredType))
 		return
 	}
 
-	ps.StopTimer()
+	placePixel(conn, pixelMsg)
+}
+
+// placePixel places the pixel on the canvas and database, consuming one of the user's
+// available pixels unless cooldown is overridden, and broadcasts it to every connection.
+func placePixel(conn *wsConn, pixelMsg wsPixelReq) {
+	var ps = conn.user.PixelStacker
+	var override = conn.hasCooldownOverride()
+
+	if !override {
+		// Note(netux): another connection of the user may have taken the last pixel in the meantime
+		if !ps.Consume() {
+			return
+		}
+		ps.StopTimer()
+	}
 	conn.queue(wsAckForPixel{
 		ackFor("PLACE"),
 		pixelMsg.PosX,
 		pixelMsg.PosY,
 	})
 
-	ps.Consume()
+	if !override {
+		ps.SetPlacement(getPixelPlacement(conn.user, pixelMsg.PosX, pixelMsg.PosY))
+	}
+
+	if state := App.Users.Snapshot(conn.user.DBUser); state.IsShadowbanned() {
+		placeShadowbannedPixel(conn, pixelMsg)
+		return
+	}
 
 	App.Canvas.SetPixelColor(pixelMsg.PosX, pixelMsg.PosY, pixelMsg.ColorIdx)
-	if err := App.DB.PlacePixel(pixelMsg.PosX, pixelMsg.PosY, pixelMsg.ColorIdx, conn.user); err != nil {
+	App.Heatmap.Mark(pixelMsg.PosX, pixelMsg.PosY, time.Now())
+	App.Virginmap.Clear(pixelMsg.PosX, pixelMsg.PosY)
+	pixel, err := App.DB.PlacePixel(pixelMsg.PosX, pixelMsg.PosY, pixelMsg.ColorIdx, conn.user)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "cannot place pixel at (%d, %d) by user with ID %d in database: %v", pixelMsg.PosX, pixelMsg.PosY, conn.user.ID, err)
 	}
-	ps.StartTimer()
+	if !override {
+		ps.StartTimer()
+	}
+
+	if window := getUndoWindow(); err == nil && window > 0 {
+		conn.user.SetUndoablePixel(pixel, window)
+		sendCanUndo(conn, window)
+	}
 
-	if conn.user.PixelStacker.Stack == 0 {
-		if err := App.DB.SetUserCooldownExpiry(conn.user.ID, ps.CooldownEnd); err != nil {
+	if !override && ps.Stack() == 0 {
+		if err := App.DB.SetUserCooldownExpiry(conn.user.ID, ps.CooldownEnd()); err != nil {
 			fmt.Fprintf(os.Stderr, "cannot set cooldown expiry for user with ID %d in database: %v", conn.user.ID, err)
 		}
 		sendCooldown(conn, ps.GetCooldown())
 	}
 
+	broadcastPixels(pixelMsg.wsPixel)
+}
+
+// getPixelPlacement describes the placement of the user over the most recent pixel at the position.
+func getPixelPlacement(u *User, x, y uint) Placement {
+	pixel, err := App.DB.GetPixelAt(x, y)
+	if err != nil {
+		if !IsNotFoundError(err) {
+			fmt.Fprintf(os.Stderr, "cannot fetch pixel at (%d, %d) from database: %v\n", x, y, err)
+		}
+		return getPlacement(u, nil)
+	}
+	return getPlacement(u, pixel)
+}
+
+// placeShadowbannedPixel pretends to place the pixel by only sending it
+// to the user's own connections, leaving the canvas and database untouched.
+func placeShadowbannedPixel(conn *wsConn, pixelMsg wsPixelReq) {
+	var ps = conn.user.PixelStacker
+
+	if !conn.hasCooldownOverride() {
+		ps.StartTimer()
+		if ps.Stack() == 0 {
+			sendCooldown(conn, ps.GetCooldown())
+		}
+	}
+
+	pixelsMsg := wsPixelRes{
+		withType(wsPixelType),
+		[]wsPixel{pixelMsg.wsPixel},
+	}
+	for _, userConn := range connectionsOfUser(conn.user.ID) {
+		userConn.queue(pixelsMsg)
+	}
+}
+
+// broadcastPixels sends a "pixel" message with the given pixels to every connection.
+func broadcastPixels(pixels ...wsPixel) {
 	pixelsMsg := wsPixelRes{
 		wsMessage{
 			Type: wsPixelType,
 		},
-		[]wsPixel{pixelMsg.wsPixel},
+		pixels,
 	}
-	for _, conn := range Connections {
-		conn.queue(pixelsMsg)
+	Connections.Broadcast(pixelsMsg)
+}
+
+const wsUsersType = "users"
+
+// UsersBroadcastDelay is how long to wait after a connection opens or closes
+// before broadcasting the online count, so bursts are sent as a single message.
+const UsersBroadcastDelay = 2 * time.Second
+
+type wsUsers struct {
+	wsMessage
+	Count int `json:"count"`
+}
+
+// countOnline returns the amount of different users (or IPs, if not logged in) connected.
+func countOnline() int {
+	return Connections.CountOnline()
+}
+
+func sendUsers(conn *wsConn) {
+	conn.queue(wsUsers{withType(wsUsersType), countOnline()})
+}
+
+var usersBroadcastPending int32
+
+// scheduleUsersBroadcast sends the online count to every connection
+// after UsersBroadcastDelay, unless a broadcast is already scheduled.
+func scheduleUsersBroadcast() {
+	if !atomic.CompareAndSwapInt32(&usersBroadcastPending, 0, 1) {
+		return
+	}
+
+	time.AfterFunc(UsersBroadcastDelay, func() {
+		atomic.StoreInt32(&usersBroadcastPending, 0)
+
+		Connections.Broadcast(wsUsers{withType(wsUsersType), countOnline()})
+	})
+}
+
+const wsSessionLimitType = "session_limit"
+
+const wsUndoType = "undo"
+const wsCanUndoType = "can_undo"
+
+type wsCanUndo struct {
+	wsMessage
+	Time float32 `json:"time"`
+}
+
+// getUndoWindow returns the time users have to undo their last placed pixel.
+func getUndoWindow() time.Duration {
+	return App.Conf.GetTimeDurationInfiniteNotAllowed("undo.window", 5*time.Second)
+}
+
+func sendCanUndo(conn *wsConn, window time.Duration) {
+	conn.queue(wsCanUndo{
+		withType(wsCanUndoType),
+		float32(window) / float32(time.Second),
+	})
+}
+
+func handleUndo(conn *wsConn) {
+	if conn.user == nil || isShuttingDown() {
+		return
+	}
+
+	pixel, ok := conn.user.TakeUndoablePixel()
+	if !ok {
+		return
+	}
+
+	if !App.Limits.Allow("undo", fmt.Sprint(conn.user.ID)) {
+		return
+	}
+
+	prevPixel, err := App.DB.UndoPixel(pixel, conn.user)
+	if err != nil {
+		if !IsNotFoundError(err) {
+			fmt.Fprintf(os.Stderr, "cannot undo pixel with ID %d by user with ID %d in database: %v\n", pixel.ID, conn.user.ID, err)
+		}
+		return
 	}
+
+	var color = byte(App.Conf.GetInt32("board.defaultColor"))
+	if prevPixel != nil {
+		color = prevPixel.ColorIdx
+	}
+	App.Canvas.SetPixelColor(pixel.PosX, pixel.PosY, color)
+
+	conn.queue(ackFor("UNDO"))
+	if !conn.hasCooldownOverride() {
+		conn.user.PixelStacker.Gain()
+	}
+
+	broadcastPixels(wsPixel{pixel.PosX, pixel.PosY, color})
+}
+
+const wsCooldownOverrideType = "admin_cdoverride"
+
+type wsCooldownOverrideReq struct {
+	wsMessage
+	Override bool `json:"override"`
+}
+
+// handleCooldownOverride toggles cooldown override for the connection
+// if its user is granted CooldownOverridePermission.
+func handleCooldownOverride(conn *wsConn, overrideMsg wsCooldownOverrideReq) {
+	if !hasPermission(conn.user, CooldownOverridePermission) {
+		return
+	}
+
+	conn.setCooldownOverride(overrideMsg.Override)
+	sendUserInfo(conn)
 }
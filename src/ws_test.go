@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// drainMessages discards the messages queued on a connection created with newTestConn
+// until it's closed, as the connection's writer would.
+func drainMessages(conn *wsConn) {
+	go func() {
+		for {
+			select {
+			case <-conn.sendQueue:
+			case <-conn.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Note(netux): run with -race, most of what this checks is that nothing is reported
+func TestPlacePixelConcurrently(t *testing.T) {
+	const (
+		userCount        = 10
+		connsPerUser     = 3
+		placementsByConn = 30
+	)
+	setupTestApp(t, "")
+
+	mod := newTestUser(t, "mod")
+	App.Users.Update(mod.DBUser, func(u *DBUser) { u.Role = AdminUserRole })
+
+	var users []*User
+	var conns []*wsConn
+	for i := 0; i < userCount; i++ {
+		u := newTestUser(t, fmt.Sprintf("user%d", i))
+		u.PixelStacker.Restore(placementsByConn, nil)
+		// Note(netux): connections resume the timer when they open
+		u.PixelStacker.ResumeTimer()
+		users = append(users, u)
+
+		for j := 0; j < connsPerUser; j++ {
+			conn := newTestConn(t, u)
+			if err := Connections.Register(conn, connsPerUser); err != nil {
+				t.Fatalf("cannot register connection: %v", err)
+			}
+			t.Cleanup(Connections.Done)
+			drainMessages(conn)
+			conns = append(conns, conn)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn *wsConn) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(i)))
+			for j := 0; j < placementsByConn; j++ {
+				handlePixel(conn, wsPixelReq{withType(wsPixelType), wsPixel{
+					PosX:     uint(r.Intn(int(App.Canvas.Width))),
+					PosY:     uint(r.Intn(int(App.Canvas.Height))),
+					ColorIdx: byte(r.Intn(len(App.Palette))),
+				}})
+
+				switch j % 10 {
+				case 3:
+					conn.user.PixelStacker.Gain()
+				case 5:
+					conn.setCooldownOverride(!conn.hasCooldownOverride())
+					sendUserInfo(conn)
+				case 7:
+					handleUndo(conn)
+				}
+			}
+		}(i, conn)
+	}
+
+	// Note(netux): what's read and changed by other goroutines while placing
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			App.Heatmap.Bytes(time.Now())
+			App.Virginmap.Snapshot()
+			Connections.CountOnline()
+			App.Users.SaveStackers()
+
+			target := users[i%userCount]
+			expiry := time.Now().Add(time.Hour)
+			state := App.Users.Snapshot(target.DBUser)
+			setBan(httptest.NewRecorder(), mod, target.DBUser, &expiry, "test", tempbannedRole(&state))
+			setBan(httptest.NewRecorder(), mod, target.DBUser, nil, "", DefaultUserRole)
+		}
+	}()
+	wg.Wait()
+
+	var max = uint(App.Conf.GetInt32("stacking.maxStacked"))
+	for _, u := range users {
+		if stack := u.PixelStacker.Stack(); stack > placementsByConn && stack > max+1 {
+			t.Errorf("user %s has %d pixels, more than they started with or can stack", u.Name, stack)
+		}
+
+		var placed, undone uint64
+		App.DB.sql.Model(&DBPixel{}).Where("who = ? AND NOT undo_action", u.ID).Count(&placed)
+		App.DB.sql.Model(&DBPixel{}).Where("who = ? AND undone", u.ID).Count(&undone)
+		if state := App.Users.Snapshot(u.DBUser); state.PixelCount != placed-undone {
+			t.Errorf("user %s has a pixel count of %d, but placed %d pixels and undid %d", u.Name, state.PixelCount, placed, undone)
+		}
+	}
+}
+
+// getUserInfo returns the first "userinfo" message in msgs.
+func getUserInfo(t *testing.T, msgs []interface{}) wsUserInfo {
+	for _, msg := range msgs {
+		if info, ok := msg.(wsUserInfo); ok {
+			return info
+		}
+	}
+	t.Fatal("no userinfo message was sent")
+	return wsUserInfo{}
+}
+
+func TestSendUserInfoHidesShadowban(t *testing.T) {
+	setupTestApp(t, "")
+	u := newTestUser(t, "alice")
+	App.Users.Update(u.DBUser, func(u *DBUser) {
+		u.Role = ShadowbannedUserRole
+		u.BanReason = "griefing"
+	})
+	conn := newTestConn(t, u)
+
+	sendUserInfo(conn)
+	info := getUserInfo(t, takeMessages(conn))
+	if info.Role != DefaultUserRole {
+		t.Errorf("shadowbanned user was sent role %s, expected %s", info.Role, DefaultUserRole)
+	}
+	if info.IsBanned || info.BanReason != "" || info.BanExpiry != 0 {
+		t.Errorf("shadowbanned user was sent a ban: banned %v, reason %q, expiry %d", info.IsBanned, info.BanReason, info.BanExpiry)
+	}
+
+	expiry := time.Now().Add(time.Hour)
+	App.Users.Update(u.DBUser, func(u *DBUser) {
+		u.BanExpiry = &expiry
+		u.BanReason = "spam"
+	})
+
+	sendUserInfo(conn)
+	info = getUserInfo(t, takeMessages(conn))
+	if info.Role != DefaultUserRole {
+		t.Errorf("temporarily banned shadowbanned user was sent role %s, expected %s", info.Role, DefaultUserRole)
+	}
+	if !info.IsBanned || info.BanReason != "spam" {
+		t.Errorf("temporarily banned shadowbanned user wasn't sent their ban: banned %v, reason %q", info.IsBanned, info.BanReason)
+	}
+}
+
+// newTestSocket opens a websocket to a test server and returns both ends of it.
+func newTestSocket(t *testing.T) (server, client *websocket.Conn) {
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("cannot upgrade test socket: %v", err)
+			close(conns)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("cannot dial test socket: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, ok := <-conns
+	if !ok {
+		t.FailNow()
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+func TestQueueDisconnectsSlowClient(t *testing.T) {
+	server, client := newTestSocket(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// Note(netux): messages aren't written, as if the client wasn't reading them
+	conn := &wsConn{
+		Conn:      server,
+		ctx:       ctx,
+		cancel:    cancel,
+		ip:        "192.0.2.1",
+		sendQueue: make(chan interface{}, 2),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			conn.queue(withType(wsPixelType))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queueing on a full send queue blocked")
+	}
+
+	if conn.ctx.Err() == nil {
+		t.Error("connection wasn't closed after its send queue filled up")
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := client.ReadMessage()
+	if ne, ok := err.(net.Error); err == nil || ok && ne.Timeout() {
+		t.Errorf("reading from a connection closed for being too slow returned %v, expected it to be closed", err)
+	}
+}
+
+func TestWriteMessagesClosesOnError(t *testing.T) {
+	server, client := newTestSocket(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	conn := &wsConn{
+		Conn:      server,
+		ctx:       ctx,
+		cancel:    cancel,
+		ip:        "192.0.2.1",
+		sendQueue: make(chan interface{}, SendQueueSize),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.writeMessages()
+	}()
+
+	conn.queue(withType(wsPixelType))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := client.ReadMessage(); err != nil {
+		t.Fatalf("cannot read queued message: %v", err)
+	}
+
+	// Note(netux): writing fails once the connection is closed underneath the writer
+	server.UnderlyingConn().Close()
+	conn.queue(withType(wsPixelType))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writer kept running after failing to write")
+	}
+	if conn.ctx.Err() == nil {
+		t.Error("connection wasn't closed after failing to write")
+	}
+}
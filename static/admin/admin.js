@@ -203,7 +203,7 @@
                     }
                     if (data.chatBanned) {
                         items.push(["Chatban Reason", data.chatbanReason]);
-                        if (!data.isChatbanPerma) {
+                        if (!data.chatbanIsPerma) {
                             items.push(["Chatban Expires", chabannedExpiracyStr])
                         }
                     }